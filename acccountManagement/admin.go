@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Onboarding status of a driver. Only approved drivers may take trips.
+// Drivers are suspended like any other user, see suspension.go.
+const (
+	DriverPending  = "pending"
+	DriverApproved = "approved"
+)
+
+// Reason given when a driver is suspended without one
+const defaultDriverSuspensionReason = "Suspended by staff"
+
+// Returned when the driver status changed while it was being changed
+var errStatusChanged = errors.New("driver status was changed by another request")
+
+// Changes the status of a driver if it is currently one of the given
+// statuses, then writes the driver
+func transitionDriver(w http.ResponseWriter, r *http.Request, to string, from ...string) {
+	reqId := mux.Vars(r)["id"]
+
+	driver, err := fetchDriver(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("transitionDriver: Error in query" + err.Error())
+		return
+	}
+
+	if !containsString(from, driver.Status) {
+		writeErrorStatus(w, r, "Driver cannot become "+to+" while "+driver.Status, http.StatusConflict)
+		return
+	}
+
+	// Approved drivers must have valid documents
+	if to == DriverApproved {
+		missing, err := missingDocuments(reqId)
+		if err != nil {
+			writeError(w, r, "DB err 4")
+			log.Println("transitionDriver: Error in query" + err.Error())
+			return
+		}
+		if len(missing) > 0 {
+			writeErrorStatus(w, r, "Driver is missing valid documents: "+strings.Join(missing, ", "), http.StatusConflict)
+			return
+		}
+	}
+
+	// Only update if unchanged since it was read
+	err = accounts.transaction(func(tx *sql.Tx) error {
+		res, err := accounts.exec(tx, "UPDATE driver SET status = ?, statusUpdatedAt = ? WHERE userId = ? AND status = ?",
+			to, time.Now().Unix(), reqId, driver.Status)
+		if err != nil {
+			return err
+		}
+
+		count, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errStatusChanged
+		}
+
+		return accounts.audit(tx, reqId, "status", &driver.Status, &to, callerIdentity(r))
+	})
+	if err == errStatusChanged {
+		writeErrorStatus(w, r, "Driver status was changed by another request", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("transitionDriver: Error in exec" + err.Error())
+		return
+	}
+
+	driver.Status = to
+	fillRatings("drivers", &driver)
+
+	json.NewEncoder(w).Encode(driver)
+}
+
+func approveDriver(w http.ResponseWriter, r *http.Request) {
+	transitionDriver(w, r, DriverApproved, DriverPending)
+}
+
+// Suspends a driver, the same as suspending the user. The body with the
+// reason and expiry is optional.
+func suspendDriver(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	info := SuspendInfo{Reason: defaultDriverSuspensionReason}
+	if r.ContentLength != 0 {
+		if ensureJson(w, r, &info) != nil {
+			return
+		}
+		if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+			writeValidationErrors(w, r, fieldErrors)
+			return
+		}
+	}
+
+	_, err := fetchDriver(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("suspendDriver: Error in query" + err.Error())
+		return
+	}
+
+	err = accounts.Suspend(reqId, info.Reason, info.ExpiresAt, callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("suspendDriver: Error in exec" + err.Error())
+		return
+	}
+
+	getDriver(w, r)
+}
+
+// Lifts the suspension of a driver, once they have valid documents again
+func reinstateDriver(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	_, err := fetchDriver(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("reinstateDriver: Error in query" + err.Error())
+		return
+	}
+
+	missing, err := missingDocuments(reqId)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("reinstateDriver: Error in query" + err.Error())
+		return
+	}
+	if len(missing) > 0 {
+		writeErrorStatus(w, r, "Driver is missing valid documents: "+strings.Join(missing, ", "), http.StatusConflict)
+		return
+	}
+
+	err = accounts.Unsuspend(reqId, callerIdentity(r))
+	if err == errNotSuspended {
+		writeErrorStatus(w, r, "Driver is not suspended: "+reqId, http.StatusConflict)
+		return
+	}
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("reinstateDriver: Error in exec" + err.Error())
+		return
+	}
+
+	getDriver(w, r)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Fields recorded for changes other than to a profile field
+const (
+	auditFieldRole       = "role"
+	auditFieldPassword   = "password"
+	auditFieldStaffRole  = "staffRole"
+	auditFieldSuspension = "suspension"
+	auditFieldDeleted    = "deleted"
+)
+
+// Records a change to a field of the user, made by the actor. A nil value
+// means the field was not set. Anonymous actors are recorded as NULL.
+func (repo *accountRepository) audit(tx *sql.Tx, userId string, field string, oldValue *string, newValue *string, actor Identity) error {
+	var actorId sql.NullInt64
+	if actor.UserId != 0 {
+		actorId = sql.NullInt64{Int64: actor.UserId, Valid: true}
+	}
+	var actorRole sql.NullString
+	if actor.Role != "" {
+		actorRole = sql.NullString{String: actor.Role, Valid: true}
+	}
+
+	_, err := repo.exec(tx, `INSERT INTO user_audit
+		(userId, field, oldValue, newValue, actorId, actorRole, createdAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userId, field, oldValue, newValue, actorId, actorRole, time.Now().Unix())
+	return err
+}
+
+// Records the field if its value changed
+func (repo *accountRepository) auditChange(tx *sql.Tx, userId string, field string, oldValue sql.NullString, newValue string, actor Identity) error {
+	if oldValue.Valid && oldValue.String == newValue {
+		return nil
+	}
+
+	var old *string
+	if oldValue.Valid {
+		old = &oldValue.String
+	}
+	return repo.audit(tx, userId, field, old, &newValue, actor)
+}
+
+// --------------
+
+type AuditEntry struct {
+	Id        int64   `json:"id"`
+	Field     string  `json:"field"`
+	OldValue  *string `json:"oldValue"`
+	NewValue  *string `json:"newValue"`
+	ActorId   *int64  `json:"actorId"`
+	ActorRole *string `json:"actorRole"`
+	CreatedAt int64   `json:"createdAt"`
+}
+
+// Lists the changes made to a user, newest first
+func getUserAudit(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	var v validator
+	limit := parseListLimit(&v, query.Get("limit"))
+
+	var cursor *listCursor
+	if value := query.Get("cursor"); value != "" {
+		c, err := decodeCursor(value)
+		if err != nil {
+			v.fail("cursor", "is not valid")
+		}
+		cursor = &c
+	}
+
+	if len(v.errors) > 0 {
+		writeValidationErrors(w, r, v.errors)
+		return
+	}
+
+	var exists bool
+	err := db.QueryRow("SELECT COUNT(*) > 0 FROM user WHERE id = ?", reqId).Scan(&exists)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("getUserAudit: Error in query" + err.Error())
+		return
+	}
+	if !exists {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+
+	resp := ListResponse{
+		Items: []interface{}{},
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM user_audit WHERE userId = ?", reqId).Scan(&resp.Total)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("getUserAudit: Error in query" + err.Error())
+		return
+	}
+
+	// Entries are ordered by id, so the cursor only needs the id
+	condition := ""
+	args := []interface{}{reqId}
+	if cursor != nil {
+		condition = " AND id < ?"
+		args = append(args, cursor.Id)
+	}
+	args = append(args, limit+1)
+
+	rows, err := db.Query(`SELECT id, field, oldValue, newValue, actorId, actorRole, createdAt
+		FROM user_audit
+		WHERE userId = ?`+condition+`
+		ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("getUserAudit: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	var last AuditEntry
+	for rows.Next() {
+		if len(resp.Items) == limit {
+			resp.NextCursor = encodeCursor(listCursor{Id: last.Id})
+			break
+		}
+
+		var entry AuditEntry
+		err = rows.Scan(
+			&entry.Id, &entry.Field, &entry.OldValue, &entry.NewValue,
+			&entry.ActorId, &entry.ActorRole, &entry.CreatedAt,
+		)
+		if err != nil {
+			writeError(w, r, "DB err 4")
+			log.Println("getUserAudit: Error in scan" + err.Error())
+			return
+		}
+		resp.Items = append(resp.Items, entry)
+		last = entry
+	}
+	if err = rows.Err(); err != nil {
+		writeError(w, r, "DB err 5")
+		log.Println("getUserAudit: Error in rows" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
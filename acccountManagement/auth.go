@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Lifetimes of the issued tokens
+const accessTokenLifetime = 15 * time.Minute
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+// Minimum accepted length of a password
+const minPasswordLength = 8
+
+// Used only when SLEDAWAY_TOKEN_SECRET is not set, e.g. local development.
+// Every service must be configured with the same secret to verify tokens.
+const devTokenSecret = "sledaway-development-secret"
+
+const (
+	RolePassenger = "passenger"
+	RoleDriver    = "driver"
+)
+
+var tokenSecret []byte
+
+// Loads the token signing secret from the environment
+func loadTokenSecret() {
+	secret := os.Getenv("SLEDAWAY_TOKEN_SECRET")
+	if secret == "" {
+		log.Println("SLEDAWAY_TOKEN_SECRET is not set, using the development secret")
+		secret = devTokenSecret
+	}
+	tokenSecret = []byte(secret)
+}
+
+// Claims carried by an access token. The subject is the user id.
+type AccessClaims struct {
+	Role      string `json:"role"`
+	SessionId int64  `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// Signs a new access token for the user and role
+func issueAccessToken(userId int64, role string, sessionId int64) (string, error) {
+	now := time.Now()
+	claims := AccessClaims{
+		Role:      role,
+		SessionId: sessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatInt(userId, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenLifetime)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tokenSecret)
+}
+
+// The authenticated caller of a request
+type Identity struct {
+	UserId int64
+	Role   string
+	// Session the token was issued for, or 0 for service tokens
+	SessionId int64
+}
+
+type identityKey struct{}
+
+// Validates the bearer access token of the request and gets the caller
+func parseAccessToken(r *http.Request) (Identity, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Identity{}, errMissingToken
+	}
+
+	var claims AccessClaims
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), &claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return tokenSecret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return Identity{}, err
+	}
+
+	userId, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		UserId:    userId,
+		Role:      claims.Role,
+		SessionId: claims.SessionId,
+	}, nil
+}
+
+// Checks whether the session of the caller has been signed out, e.g. by
+// logging out or resetting the password. Service tokens have no session.
+func sessionRevoked(identity Identity) (bool, error) {
+	if identity.Role == RoleService {
+		return false, nil
+	}
+
+	var revoked bool
+	err := db.QueryRow("SELECT revokedAt IS NOT NULL FROM session WHERE id = ? AND userId = ?",
+		identity.SessionId, identity.UserId).Scan(&revoked)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return revoked, err
+}
+
+var errMissingToken = errors.New("missing bearer token")
+
+// Middleware that validates the bearer access token of the request and
+// stores the caller identity in the request context
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := parseAccessToken(r)
+		if err == errMissingToken {
+			writeErrorStatus(w, r, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			writeErrorStatus(w, r, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		revoked, err := sessionRevoked(identity)
+		if err != nil {
+			writeError(w, r, "DB err 1")
+			log.Println("authenticate: Error in session" + err.Error())
+			return
+		}
+		if revoked {
+			writeErrorStatus(w, r, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, withIdentity(r, identity))
+	})
+}
+
+func withIdentity(r *http.Request, identity Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+}
+
+// Gets the caller identity stored by authenticate
+func callerIdentity(r *http.Request) Identity {
+	identity, _ := r.Context().Value(identityKey{}).(Identity)
+	return identity
+}
+
+// Ensures the caller is signed in with the role and is the user with the id
+// given in the request path
+func ensureCallerPath(w http.ResponseWriter, r *http.Request, role string, reqId string) bool {
+	identity := callerIdentity(r)
+	if identity.Role != role {
+		writeErrorStatus(w, r, "Only a "+role+" may do this", http.StatusForbidden)
+		return false
+	}
+
+	if reqId != strconv.FormatInt(identity.UserId, 10) {
+		writeErrorStatus(w, r, "You may not act on behalf of another "+role, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Generates a random opaque token, e.g. a refresh token, and the hash stored
+// for it
+func newOpaqueToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, hashOpaqueToken(token), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Hashes a password for storing in the credential table
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// Checks that the password is acceptable for an account
+func checkPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return errors.New("Password must be at least " + strconv.Itoa(minPasswordLength) + " characters")
+	}
+	return nil
+}
+
+// Checks whether the user has the given role
+func userHasRole(userId int64, role string) (bool, error) {
+	var query string
+	switch role {
+	case RolePassenger:
+		query = "SELECT COUNT(*) FROM passenger WHERE userId = ?"
+	case RoleDriver:
+		query = "SELECT COUNT(*) FROM driver WHERE userId = ?"
+	case RoleSupport, RoleAdmin:
+		return userHasStaffRole(userId, role)
+	default:
+		return false, nil
+	}
+
+	var count int
+	err := db.QueryRow(query, userId).Scan(&count)
+	return count > 0, err
+}
+
+// Creates a session for the user and writes the issued tokens
+func writeNewSession(w http.ResponseWriter, r *http.Request, userId int64, role string) {
+	refreshToken, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		writeErrorStatus(w, r, "Could not generate token", http.StatusInternalServerError)
+		log.Println("writeNewSession: Error in token" + err.Error())
+		return
+	}
+
+	stmt, err := db.Prepare(`INSERT INTO session
+		(userId, role, refreshTokenHash, expiresAt)
+		VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("writeNewSession: Error in prepare" + err.Error())
+		return
+	}
+
+	res, err := stmt.Exec(userId, role, refreshHash, time.Now().Add(refreshTokenLifetime).Unix())
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("writeNewSession: Error in exec" + err.Error())
+		return
+	}
+
+	sessionId, err := res.LastInsertId()
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("writeNewSession: Error in last id" + err.Error())
+		return
+	}
+
+	accessToken, err := issueAccessToken(userId, role, sessionId)
+	if err != nil {
+		writeErrorStatus(w, r, "Could not generate token", http.StatusInternalServerError)
+		log.Println("writeNewSession: Error in sign" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenLifetime / time.Second),
+		UserId:       userId,
+		Role:         role,
+	})
+}
+
+// --------------
+
+type LoginInfo struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// Optional if the user only holds a single role
+	Role string `json:"role"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	UserId       int64  `json:"userId"`
+	Role         string `json:"role"`
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	var info LoginInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	var userId int64
+	var passwordHash string
+	err := db.QueryRow(`SELECT
+		u.id, c.passwordHash
+		FROM user u INNER JOIN credential c ON u.id = c.userId
+		WHERE u.email = ? AND u.deletedAt IS NULL`, info.Email).Scan(&userId, &passwordHash)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(info.Password))
+	}
+	if err != nil {
+		writeErrorStatus(w, r, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+
+	// Determine the role to sign in as
+	isPassenger, err := userHasRole(userId, RolePassenger)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("login: Error in role" + err.Error())
+		return
+	}
+	isDriver, err := userHasRole(userId, RoleDriver)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("login: Error in role" + err.Error())
+		return
+	}
+
+	role := info.Role
+	switch {
+	case role == "" && isPassenger && !isDriver:
+		role = RolePassenger
+	case role == "" && isDriver && !isPassenger:
+		role = RoleDriver
+	case role == "":
+		writeError(w, r, "Please specify a role to sign in as")
+		return
+	case role == RolePassenger && !isPassenger, role == RoleDriver && !isDriver:
+		writeErrorStatus(w, r, "Account does not have the role: "+role, http.StatusForbidden)
+		return
+	case containsString(staffRoles, role):
+		// Staff roles are never chosen implicitly
+		isStaff, err := userHasRole(userId, role)
+		if err != nil {
+			writeError(w, r, "DB err 3")
+			log.Println("login: Error in role" + err.Error())
+			return
+		}
+		if !isStaff {
+			writeErrorStatus(w, r, "Account does not have the role: "+role, http.StatusForbidden)
+			return
+		}
+	case role != RolePassenger && role != RoleDriver:
+		writeError(w, r, "Unknown role: "+role)
+		return
+	}
+
+	writeNewSession(w, r, userId, role)
+}
+
+type RefreshInfo struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Exchanges a refresh token for a new pair of tokens. The old refresh token
+// is revoked.
+func refreshSession(w http.ResponseWriter, r *http.Request) {
+	var info RefreshInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	var sessionId, userId int64
+	var role string
+	err := db.QueryRow(`SELECT
+		id, userId, role
+		FROM session
+		WHERE refreshTokenHash = ? AND revokedAt IS NULL AND expiresAt > ?`,
+		hashOpaqueToken(info.RefreshToken), time.Now().Unix(),
+	).Scan(&sessionId, &userId, &role)
+	if err != nil {
+		writeErrorStatus(w, r, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// The role may have been taken away since signing in
+	hasRole, err := userHasRole(userId, role)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("refreshSession: Error in role" + err.Error())
+		return
+	}
+	if !hasRole {
+		writeErrorStatus(w, r, "Account no longer has the role: "+role, http.StatusForbidden)
+		return
+	}
+
+	res, err := db.Exec("UPDATE session SET revokedAt = ? WHERE id = ? AND revokedAt IS NULL",
+		time.Now().Unix(), sessionId)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("refreshSession: Error in exec" + err.Error())
+		return
+	}
+
+	// Another request may have used the same token in the meantime
+	count, err := res.RowsAffected()
+	if err != nil || count == 0 {
+		writeErrorStatus(w, r, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	writeNewSession(w, r, userId, role)
+}
+
+// Revokes the session of the given refresh token
+func logout(w http.ResponseWriter, r *http.Request) {
+	var info RefreshInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	res, err := db.Exec("UPDATE session SET revokedAt = ? WHERE refreshTokenHash = ? AND revokedAt IS NULL",
+		time.Now().Unix(), hashOpaqueToken(info.RefreshToken))
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("logout: Error in exec" + err.Error())
+		return
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		return
+	}
+
+	if count == 0 {
+		writeErrorStatus(w, r, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+}
+
+type SessionResponse struct {
+	UserId    int64  `json:"userId"`
+	Role      string `json:"role"`
+	SessionId int64  `json:"sessionId"`
+}
+
+// Gets the session of the caller's access token. authenticate rejects tokens
+// of signed out sessions, so the other services call this to check them.
+func getSession(w http.ResponseWriter, r *http.Request) {
+	identity := callerIdentity(r)
+	json.NewEncoder(w).Encode(SessionResponse{
+		UserId:    identity.UserId,
+		Role:      identity.Role,
+		SessionId: identity.SessionId,
+	})
+}
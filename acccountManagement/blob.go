@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returned when no blob is stored with the key
+var errBlobNotFound = errors.New("blob not found")
+
+// Stores uploaded files by key. Keys are paths separated with "/".
+type BlobStore interface {
+	Put(key string, content io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+var blobs BlobStore
+
+// Sets up the blob store. Until an object storage service is configured, blobs
+// are kept in the directory in SLEDAWAY_BLOB_DIR, or ./blobs if not set.
+func loadBlobStore() {
+	dir := os.Getenv("SLEDAWAY_BLOB_DIR")
+	if dir == "" {
+		log.Println("SLEDAWAY_BLOB_DIR is not set, storing uploads in ./blobs")
+		dir = "blobs"
+	}
+	blobs = localBlobStore{dir: dir}
+}
+
+// Keeps blobs as files under a directory
+type localBlobStore struct {
+	dir string
+}
+
+// Gets the file path of a key, which must stay inside the directory
+func (s localBlobStore) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") {
+		return "", errors.New("invalid blob key: " + key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s localBlobStore) Put(key string, content io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// Written to a temporary file first, so a failed upload leaves nothing
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (s localBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return file, err
+}
+
+func (s localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Returned when the user cannot be deleted as they are in a trip
+var errOngoingTrip = errors.New("user has an ongoing trip")
+
+// Asks tripManagement whether the user has an ongoing trip in any role. The
+// caller's access token is forwarded.
+func checkNoOngoingTrip(r *http.Request, userId string) error {
+	request, err := http.NewRequest(http.MethodGet,
+		tripManagementApiUrl+"/api/v1/users/"+userId+"/trip", nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+	resp, err := serviceClient.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil
+	case http.StatusOK:
+		return errOngoingTrip
+	default:
+		return errors.New("unexpected status from tripManagement: " + resp.Status)
+	}
+}
+
+// Soft-deletes the user and replaces their personal data with tombstones.
+// Records referring to the user id, e.g. trip history, are kept and now point
+// to the anonymized user. Values in the user's audit trail are erased too.
+func anonymizeUser(userId string, actor Identity) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+
+	// Tombstones include the id to keep them unique
+	_, err = tx.Exec(`UPDATE user
+		SET firstName = 'Deleted', lastName = 'User',
+			mobileNo = CONCAT('deleted-', id), email = CONCAT('deleted-', id, '@invalid'),
+			verifiedEmail = NULL, verifiedMobileNo = NULL, deletedAt = ?
+		WHERE id = ? AND deletedAt IS NULL`, now, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE driver
+		SET identificationNo = CONCAT('deleted-', userId), carNo = ''
+		WHERE userId = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM saved_place WHERE passengerId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM verification_code WHERE userId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	// Files are removed once the deletion is committed
+	rows, err := tx.Query("SELECT blobKey FROM driver_document WHERE driverId = ?", userId)
+	if err != nil {
+		return err
+	}
+	var blobKeys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		blobKeys = append(blobKeys, key)
+	}
+	rows.Close()
+
+	_, err = tx.Exec("DELETE FROM driver_document WHERE driverId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM vehicle WHERE driverId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM password_reset WHERE userId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM suspension WHERE userId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM staff WHERE userId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	// Prevent signing in again
+	_, err = tx.Exec("DELETE FROM credential WHERE userId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE session SET revokedAt = ? WHERE userId = ? AND revokedAt IS NULL", now, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE user_audit SET oldValue = NULL, newValue = NULL WHERE userId = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	err = accounts.audit(tx, userId, auditFieldDeleted, nil, nil, actor)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range blobKeys {
+		if err = blobs.Delete(key); err != nil {
+			log.Println("anonymizeUser: Error in delete" + err.Error())
+		}
+	}
+	return nil
+}
+
+// Deletes the account of the signed in user with the role
+func deleteAccount(w http.ResponseWriter, r *http.Request, role string, reqId string) {
+	if !ensureCallerPath(w, r, role, reqId) {
+		return
+	}
+
+	var deletedAt *int64
+	err := db.QueryRow("SELECT deletedAt FROM user WHERE id = ?", reqId).Scan(&deletedAt)
+	if err != nil || deletedAt != nil {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+
+	err = checkNoOngoingTrip(r, reqId)
+	if err == errOngoingTrip {
+		writeErrorStatus(w, r, "You cannot delete your account during an ongoing trip.", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeErrorStatus(w, r, "Could not check for ongoing trips", http.StatusBadGateway)
+		log.Println("deleteAccount: Error in trip check" + err.Error())
+		return
+	}
+
+	err = anonymizeUser(reqId, callerIdentity(r))
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("deleteAccount: Error in anonymize" + err.Error())
+		return
+	}
+
+	log.Println("deleteAccount: Deleted " + role + " " + reqId)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Types of documents a driver uploads
+const (
+	DocumentLicence   = "licence"
+	DocumentInsurance = "insurance"
+	DocumentPhoto     = "photo"
+)
+
+// Content types accepted for each document type, as detected from the file
+var documentContentTypes = map[string][]string{
+	DocumentLicence:   {"application/pdf", "image/jpeg", "image/png"},
+	DocumentInsurance: {"application/pdf", "image/jpeg", "image/png"},
+	DocumentPhoto:     {"image/jpeg", "image/png"},
+}
+
+// Documents which must be valid before a driver is approved. They also need
+// an expiry date.
+var requiredDocuments = []string{DocumentLicence, DocumentInsurance}
+
+const maxDocumentSize = 5 << 20
+
+// How long a download link works for
+const documentLinkLifetime = 15 * time.Minute
+
+type DocumentInfo struct {
+	Id          int64   `json:"id"`
+	Type        string  `json:"type"`
+	ContentType string  `json:"contentType"`
+	Size        int64   `json:"size"`
+	ExpiresOn   *string `json:"expiresOn"`
+	Expired     bool    `json:"expired"`
+	UploadedAt  int64   `json:"uploadedAt"`
+	Url         string  `json:"url"`
+}
+
+const documentColumns = "id, type, contentType, size, DATE_FORMAT(expiresOn, '%Y-%m-%d'), expiresOn < CURDATE(), uploadedAt"
+
+func scanDocument(row interface{ Scan(...interface{}) error }, info *DocumentInfo) error {
+	var expired sql.NullBool
+	err := row.Scan(
+		&info.Id, &info.Type, &info.ContentType, &info.Size,
+		&info.ExpiresOn, &expired, &info.UploadedAt,
+	)
+	info.Expired = expired.Bool
+	info.Url = documentUrl(info.Id, time.Now().Add(documentLinkLifetime))
+	return err
+}
+
+// Gets the documents of a driver. Only the photo is included unless all is
+// set, as the others hold personal data.
+func fetchDocuments(driverId string, all bool) ([]DocumentInfo, error) {
+	query := "SELECT " + documentColumns + " FROM driver_document WHERE driverId = ?"
+	if !all {
+		query += " AND type = '" + DocumentPhoto + "'"
+	}
+
+	rows, err := db.Query(query+" ORDER BY type", driverId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []DocumentInfo{}
+	for rows.Next() {
+		var info DocumentInfo
+		if err = scanDocument(rows, &info); err != nil {
+			return nil, err
+		}
+		documents = append(documents, info)
+	}
+	return documents, rows.Err()
+}
+
+// Gets the required documents that the driver is missing, or has expired
+func missingDocuments(driverId string) ([]string, error) {
+	documents, err := fetchDocuments(driverId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, documentType := range requiredDocuments {
+		valid := false
+		for _, document := range documents {
+			if document.Type == documentType && !document.Expired {
+				valid = true
+			}
+		}
+		if !valid {
+			missing = append(missing, documentType)
+		}
+	}
+	return missing, nil
+}
+
+// Signs a download link, so it can be used without an access token, e.g. in
+// an image
+func signDocumentLink(id int64, expires int64) string {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte("document:" + strconv.FormatInt(id, 10) + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Gets a download link of the document that works until the expiry
+func documentUrl(id int64, expiry time.Time) string {
+	expires := expiry.Unix()
+	return "/api/v1/documents/" + strconv.FormatInt(id, 10) +
+		"/file?expires=" + strconv.FormatInt(expires, 10) +
+		"&signature=" + signDocumentLink(id, expires)
+}
+
+// Generates a random key to store a document under
+func newDocumentKey(driverId string, documentType string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "drivers/" + driverId + "/" + documentType + "-" + hex.EncodeToString(buf), nil
+}
+
+// Gets the document type of the request path. Returns whether to continue.
+func ensureDocumentType(w http.ResponseWriter, r *http.Request) (string, bool) {
+	documentType := mux.Vars(r)["type"]
+	if _, ok := documentContentTypes[documentType]; !ok {
+		writeErrorStatus(w, r, "Unknown document type: "+documentType, http.StatusNotFound)
+		return "", false
+	}
+	return documentType, true
+}
+
+// --------------
+
+type ListDocumentsResponse struct {
+	Documents []DocumentInfo `json:"documents"`
+}
+
+// Lists the documents of the signed in driver, or any driver for reviewers
+func listDocuments(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+
+	if !hasPermission(callerIdentity(r), PermReviewDrivers) && !ensureSelfDriver(w, r, driverId) {
+		return
+	}
+
+	documents, err := fetchDocuments(driverId, true)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("listDocuments: Error in query" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(ListDocumentsResponse{
+		Documents: documents,
+	})
+}
+
+// Uploads a document of the signed in driver as multipart/form-data, with the
+// file in "file" and the expiry date in "expiresOn" (YYYY-MM-DD). Replaces the
+// driver's previous document of the type.
+func uploadDocument(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+
+	documentType, ok := ensureDocumentType(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-type"))
+	if mediaType != "multipart/form-data" {
+		writeErrorStatus(w, r, "Expected Content-type = multipart/form-data", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if !ensureSelfDriver(w, r, driverId) {
+		return
+	}
+
+	// Allow for the other form fields around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxDocumentSize+64<<10)
+	err := r.ParseMultipartForm(maxDocumentSize)
+	if err != nil {
+		writeErrorStatus(w, r, "Could not read upload, files may be at most "+strconv.Itoa(maxDocumentSize>>20)+" MB",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeValidationErrors(w, r, []FieldError{{Field: "file", Message: "is required"}})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxDocumentSize {
+		writeErrorStatus(w, r, "Files may be at most "+strconv.Itoa(maxDocumentSize>>20)+" MB",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var v validator
+
+	// The declared content type is not trusted
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		writeError(w, r, "Could not read upload")
+		return
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !containsString(documentContentTypes[documentType], contentType) {
+		v.fail("file", "must be one of "+strings.Join(documentContentTypes[documentType], ", "))
+	}
+
+	var expiresOn *string
+	if value := r.FormValue("expiresOn"); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			v.fail("expiresOn", "must be a date as YYYY-MM-DD")
+		} else if !date.After(time.Now()) {
+			v.fail("expiresOn", "must be in the future")
+		}
+		expiresOn = &value
+	} else if containsString(requiredDocuments, documentType) {
+		v.required("expiresOn", "")
+	}
+
+	if len(v.errors) > 0 {
+		writeValidationErrors(w, r, v.errors)
+		return
+	}
+
+	key, err := newDocumentKey(driverId, documentType)
+	if err != nil {
+		writeErrorStatus(w, r, "Could not generate key", http.StatusInternalServerError)
+		log.Println("uploadDocument: Error in key" + err.Error())
+		return
+	}
+
+	err = blobs.Put(key, io.MultiReader(bytes.NewReader(head), file))
+	if err != nil {
+		writeErrorStatus(w, r, "Could not store upload", http.StatusInternalServerError)
+		log.Println("uploadDocument: Error in put" + err.Error())
+		return
+	}
+
+	// The previous document is removed once replaced
+	var oldKey sql.NullString
+	var info DocumentInfo
+	err = accounts.transaction(func(tx *sql.Tx) error {
+		var oldId sql.NullString
+		err := tx.QueryRow("SELECT id, blobKey FROM driver_document WHERE driverId = ? AND type = ? FOR UPDATE",
+			driverId, documentType).Scan(&oldId, &oldKey)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		_, err = accounts.exec(tx, "DELETE FROM driver_document WHERE driverId = ? AND type = ?", driverId, documentType)
+		if err != nil {
+			return err
+		}
+
+		res, err := accounts.exec(tx, `INSERT INTO driver_document
+			(driverId, type, blobKey, contentType, size, expiresOn, uploadedAt)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			driverId, documentType, key, contentType, header.Size, expiresOn, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+
+		info.Id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		newId := strconv.FormatInt(info.Id, 10)
+		return accounts.auditChange(tx, driverId, documentType+"Document", oldId, newId, callerIdentity(r))
+	})
+	if err != nil {
+		blobs.Delete(key)
+		writeError(w, r, "DB err 2")
+		log.Println("uploadDocument: Error in exec" + err.Error())
+		return
+	}
+
+	if oldKey.Valid {
+		if err = blobs.Delete(oldKey.String); err != nil {
+			log.Println("uploadDocument: Error in delete" + err.Error())
+		}
+	}
+
+	err = scanDocument(db.QueryRow("SELECT "+documentColumns+" FROM driver_document WHERE id = ?", info.Id), &info)
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("uploadDocument: Error in query" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// Removes a document of the signed in driver
+func deleteDocument(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+
+	documentType, ok := ensureDocumentType(w, r)
+	if !ok {
+		return
+	}
+
+	if !ensureSelfDriver(w, r, driverId) {
+		return
+	}
+
+	var key string
+	err := accounts.transaction(func(tx *sql.Tx) error {
+		var id sql.NullString
+		err := tx.QueryRow("SELECT id, blobKey FROM driver_document WHERE driverId = ? AND type = ? FOR UPDATE",
+			driverId, documentType).Scan(&id, &key)
+		if err != nil {
+			return err
+		}
+
+		_, err = accounts.exec(tx, "DELETE FROM driver_document WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+
+		return accounts.audit(tx, driverId, documentType+"Document", &id.String, nil, callerIdentity(r))
+	})
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Document not found: "+documentType, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("deleteDocument: Error in exec" + err.Error())
+		return
+	}
+
+	if err = blobs.Delete(key); err != nil {
+		log.Println("deleteDocument: Error in delete" + err.Error())
+	}
+}
+
+// Downloads a document through a signed link
+func downloadDocument(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	id, err := strconv.ParseInt(reqId, 10, 64)
+	if err != nil {
+		writeErrorStatus(w, r, "Document not found: "+reqId, http.StatusNotFound)
+		return
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	signature := []byte(query.Get("signature"))
+	if err != nil || !hmac.Equal(signature, []byte(signDocumentLink(id, expires))) {
+		writeErrorStatus(w, r, "Invalid link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() >= expires {
+		writeErrorStatus(w, r, "The link has expired", http.StatusForbidden)
+		return
+	}
+
+	var key, contentType string
+	err = db.QueryRow("SELECT blobKey, contentType FROM driver_document WHERE id = ?", id).Scan(&key, &contentType)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Document not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("downloadDocument: Error in query" + err.Error())
+		return
+	}
+
+	content, err := blobs.Get(key)
+	if err == errBlobNotFound {
+		writeErrorStatus(w, r, "Document not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeErrorStatus(w, r, "Could not read document", http.StatusInternalServerError)
+		log.Println("downloadDocument: Error in get" + err.Error())
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(documentLinkLifetime/time.Second)))
+	io.Copy(w, content)
+}
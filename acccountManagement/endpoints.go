@@ -8,12 +8,23 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 )
 
 var db *sql.DB
 
+const tripHistoryApiUrl = "http://localhost:21802"
+const tripManagementApiUrl = "http://localhost:21803"
+
+// Client for calls to the other services, so a service that hangs cannot
+// hold up requests here
+var serviceClient = &http.Client{Timeout: 5 * time.Second}
+
 // --------------
 // Structures and common function
 // --------------
@@ -44,6 +55,34 @@ func writeErrorStatus(w http.ResponseWriter, r *http.Request, description string
 	})
 }
 
+// Gets the field that already holds the value, if the error is from a unique
+// key in the DB. Unique keys are named after their column.
+func duplicateField(err error) (string, bool) {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+		return "", false
+	}
+
+	// e.g. Duplicate entry 'a@b.com' for key 'email', or 'user.email' on MySQL 8
+	key := mysqlErr.Message[strings.LastIndex(mysqlErr.Message, " ")+1:]
+	key = strings.Trim(key, "'")
+	key = key[strings.LastIndex(key, ".")+1:]
+	return key, true
+}
+
+// Writes a conflict response for a field which value is already in use
+func writeConflict(w http.ResponseWriter, r *http.Request, field string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(ValidationResponse{
+		Status:      false,
+		Description: "Already in use: " + field,
+		Errors: []FieldError{
+			{Field: field, Message: "is already in use"},
+		},
+	})
+}
+
 // Ensures that the request is a json and converts it
 func ensureJson(w http.ResponseWriter, r *http.Request, v interface{}) error {
 	if r.Header.Get("Content-type") != "application/json" {
@@ -84,6 +123,7 @@ type CreatePassengerInfo struct {
 	LastName  string `json:"lastName"`
 	MobileNo  string `json:"mobileNo"`
 	Email     string `json:"email"`
+	Password  string `json:"password"`
 }
 
 type CreatePassengerResponse struct {
@@ -96,42 +136,25 @@ func createPassenger(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Println(info)
-
-	// Insert user table
-	stmt, err := db.Prepare("INSERT INTO `user` (`firstName`, `lastName`, `mobileNo`, `email`) VALUES (?, ?, ?, ?)")
-	if err != nil {
-		writeError(w, r, "DB err 1")
-		log.Println("createPassenger: Error in prepare" + err.Error())
-		return
-	}
-
-	res, err := stmt.Exec(info.FirstName, info.LastName, info.MobileNo, info.Email)
-	if err != nil {
-		writeError(w, r, "DB err 2")
-		log.Println("createPassenger: Error in exec" + err.Error())
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
 		return
 	}
 
-	id, err := res.LastInsertId()
+	passwordHash, err := hashPassword(info.Password)
 	if err != nil {
-		writeError(w, r, "DB err 3")
-		log.Println("createPassenger: Error in last id" + err.Error())
+		writeErrorStatus(w, r, "Could not hash password", http.StatusInternalServerError)
+		log.Println("createPassenger: Error in hash" + err.Error())
 		return
 	}
 
-	// Insert passenger table
-	stmt, err = db.Prepare("INSERT INTO `passenger` (`userId`) VALUES (?)")
-	if err != nil {
-		writeError(w, r, "DB err 4")
-		log.Println("createPassenger: Error in prepare" + err.Error())
+	id, err := accounts.CreatePassenger(info, passwordHash)
+	if writeAccountError(w, r, "", err) {
 		return
 	}
-
-	res, err = stmt.Exec(id)
 	if err != nil {
-		writeError(w, r, "DB err 5")
-		log.Println("createPassenger: Error in exec" + err.Error())
+		writeError(w, r, "DB err 1")
+		log.Println("createPassenger: Error in create" + err.Error())
 		return
 	}
 
@@ -141,32 +164,53 @@ func createPassenger(w http.ResponseWriter, r *http.Request) {
 }
 
 type GetPassengerResponse struct {
-	Id        int64  `json:"id"`
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	MobileNo  string `json:"mobileNo"`
-	Email     string `json:"email"`
+	Id             int64           `json:"id"`
+	FirstName      string          `json:"firstName"`
+	LastName       string          `json:"lastName"`
+	MobileNo       string          `json:"mobileNo"`
+	Email          string          `json:"email"`
+	EmailVerified  bool            `json:"emailVerified"`
+	MobileVerified bool            `json:"mobileVerified"`
+	Suspension     *SuspensionInfo `json:"suspension"`
+	UserRating
 }
 
-func getPassenger(w http.ResponseWriter, r *http.Request) {
+// Gets a passenger that is not deleted. Returns sql.ErrNoRows if not found.
+func fetchPassenger(id string) (GetPassengerResponse, error) {
 	var resp GetPassengerResponse
+	var suspension nullSuspension
+	err := db.QueryRow(`SELECT
+		u.id, firstName, lastName, mobileNo, email, `+verifiedColumns+`, `+suspensionColumns+`
+		FROM passenger p INNER JOIN user u on p.userId = u.id`+suspensionJoin+`
+		WHERE p.userId = ? AND u.deletedAt IS NULL`, id,
+	).Scan(
+		&resp.Id, &resp.FirstName, &resp.LastName, &resp.MobileNo, &resp.Email,
+		&resp.EmailVerified, &resp.MobileVerified,
+		&suspension.Reason, &suspension.ExpiresAt, &suspension.SuspendedAt,
+	)
+	resp.Suspension = suspension.info()
+	return resp, err
+}
+
+func getPassenger(w http.ResponseWriter, r *http.Request) {
 	reqId := mux.Vars(r)["id"]
 
-	stmt, err := db.Prepare(`SELECT
-		id, firstName, lastName, mobileNo, email
-		FROM passenger p INNER JOIN user u on p.userId = u.id
-		WHERE p.userId = ?`)
-	if err != nil {
-		writeError(w, r, "DB err 1")
-		log.Println("getPassenger: Error in prepare" + err.Error())
+	if !ensureSelfPathOr(w, r, reqId, PermReadAccounts) {
 		return
 	}
 
-	err = stmt.QueryRow(reqId).Scan(&resp.Id, &resp.FirstName, &resp.LastName, &resp.MobileNo, &resp.Email)
-	if err != nil {
+	resp, err := fetchPassenger(reqId)
+	if err == sql.ErrNoRows {
 		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("getPassenger: Error in query" + err.Error())
+		return
+	}
+
+	fillRatings("passengers", &resp)
 
 	json.NewEncoder(w).Encode(resp)
 }
@@ -179,44 +223,36 @@ type UpdatePassengerInfo struct {
 }
 
 func updatePassenger(w http.ResponseWriter, r *http.Request) {
-	var info CreatePassengerInfo
-	if ensureJson(w, r, &info) != nil {
-		return
-	}
-
 	reqId := mux.Vars(r)["id"]
 
-	stmt, err := db.Prepare(`UPDATE user u INNER JOIN passenger p ON u.id = p.userId
-		SET firstName = ?, lastName = ?, mobileNo = ?, email = ?
-		WHERE u.id = ?`)
-	if err != nil {
-		writeError(w, r, "DB err 1")
-		log.Println("updatePassenger: Error in prepare" + err.Error())
+	if !ensureSelfPathOr(w, r, reqId, PermWriteAccounts) {
 		return
 	}
 
-	res, err := stmt.Exec(info.FirstName, info.LastName, info.MobileNo, info.Email, reqId)
-	if err != nil {
-		writeError(w, r, "DB err 2")
+	var info UpdatePassengerInfo
+	if ensureJson(w, r, &info) != nil {
 		return
 	}
 
-	count, err := res.RowsAffected()
-	if err != nil {
-		writeError(w, r, "DB err 3")
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
 		return
 	}
 
-	// Check if any rows were updated
-	if count == 0 {
-		writeError(w, r, "No records were updated")
+	err := accounts.UpdatePassenger(reqId, info, callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("updatePassenger: Error in update" + err.Error())
 		return
 	}
 }
 
 func deletePassenger(w http.ResponseWriter, r *http.Request) {
-	_ = mux.Vars(r)["id"]
-	writeErrorStatus(w, r, "You cannot delete your account.", http.StatusForbidden)
+	reqId := mux.Vars(r)["id"]
+	deleteAccount(w, r, RolePassenger, reqId)
 }
 
 // --------------
@@ -226,6 +262,7 @@ type CreateDriverInfo struct {
 	LastName         string `json:"lastName"`
 	MobileNo         string `json:"mobileNo"`
 	Email            string `json:"email"`
+	Password         string `json:"password"`
 	IdentificationNo string `json:"identificationNo"`
 	CarNo            string `json:"carNo"`
 }
@@ -240,42 +277,25 @@ func createDriver(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Println(info)
-
-	// Insert user table
-	stmt, err := db.Prepare("INSERT INTO `user` (`firstName`, `lastName`, `mobileNo`, `email`) VALUES (?, ?, ?, ?)")
-	if err != nil {
-		writeError(w, r, "DB err 1")
-		log.Println("createDriver: Error in prepare" + err.Error())
-		return
-	}
-
-	res, err := stmt.Exec(info.FirstName, info.LastName, info.MobileNo, info.Email)
-	if err != nil {
-		writeError(w, r, "DB err 2")
-		log.Println("createDriver: Error in exec" + err.Error())
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
 		return
 	}
 
-	id, err := res.LastInsertId()
+	passwordHash, err := hashPassword(info.Password)
 	if err != nil {
-		writeError(w, r, "DB err 3")
-		log.Println("createDriver: Error in last id" + err.Error())
+		writeErrorStatus(w, r, "Could not hash password", http.StatusInternalServerError)
+		log.Println("createDriver: Error in hash" + err.Error())
 		return
 	}
 
-	// Insert driver table
-	stmt, err = db.Prepare("INSERT INTO `driver` (`userId`, `identificationNo`, `carNo`) VALUES (?, ?, ?)")
-	if err != nil {
-		writeError(w, r, "DB err 4")
-		log.Println("createDriver: Error in prepare" + err.Error())
+	id, err := accounts.CreateDriver(info, passwordHash)
+	if writeAccountError(w, r, "", err) {
 		return
 	}
-
-	res, err = stmt.Exec(id, info.IdentificationNo, info.CarNo)
 	if err != nil {
-		writeError(w, r, "DB err 5")
-		log.Println("createDriver: Error in exec" + err.Error())
+		writeError(w, r, "DB err 1")
+		log.Println("createDriver: Error in create" + err.Error())
 		return
 	}
 
@@ -285,38 +305,81 @@ func createDriver(w http.ResponseWriter, r *http.Request) {
 }
 
 type GetDriverResponse struct {
-	Id               int64  `json:"id"`
-	FirstName        string `json:"firstName"`
-	LastName         string `json:"lastName"`
-	MobileNo         string `json:"mobileNo"`
-	Email            string `json:"email"`
-	IdentificationNo string `json:"identificationNo"`
-	CarNo            string `json:"carNo"`
+	Id               int64           `json:"id"`
+	FirstName        string          `json:"firstName"`
+	LastName         string          `json:"lastName"`
+	MobileNo         string          `json:"mobileNo"`
+	Email            string          `json:"email"`
+	EmailVerified    bool            `json:"emailVerified"`
+	MobileVerified   bool            `json:"mobileVerified"`
+	IdentificationNo string          `json:"identificationNo"`
+	CarNo            string          `json:"carNo"`
+	Status           string          `json:"status"`
+	Suspension       *SuspensionInfo `json:"suspension"`
+	ActiveVehicle    *VehicleInfo    `json:"activeVehicle,omitempty"`
+	Documents        []DocumentInfo  `json:"documents,omitempty"`
+	UserRating
 }
 
-func getDriver(w http.ResponseWriter, r *http.Request) {
+// Gets a driver that is not deleted. Returns sql.ErrNoRows if not found.
+func fetchDriver(id string) (GetDriverResponse, error) {
 	var resp GetDriverResponse
+	var suspension nullSuspension
+	err := db.QueryRow(`SELECT
+		u.id, firstName, lastName, mobileNo, email, `+verifiedColumns+`,
+		identificationNo, carNo, status, `+suspensionColumns+`
+		FROM driver d INNER JOIN user u on d.userId = u.id`+suspensionJoin+`
+		WHERE d.userId = ? AND u.deletedAt IS NULL`, id,
+	).Scan(
+		&resp.Id, &resp.FirstName, &resp.LastName,
+		&resp.MobileNo, &resp.Email,
+		&resp.EmailVerified, &resp.MobileVerified,
+		&resp.IdentificationNo, &resp.CarNo, &resp.Status,
+		&suspension.Reason, &suspension.ExpiresAt, &suspension.SuspendedAt,
+	)
+	resp.Suspension = suspension.info()
+	if err != nil {
+		return resp, err
+	}
+
+	vehicle, err := fetchActiveVehicle(id)
+	if err == sql.ErrNoRows {
+		return resp, nil
+	}
+	resp.ActiveVehicle = &vehicle
+	return resp, err
+}
+
+func getDriver(w http.ResponseWriter, r *http.Request) {
 	reqId := mux.Vars(r)["id"]
 
-	stmt, err := db.Prepare(`SELECT
-		id, firstName, lastName, mobileNo, email, identificationNo, carNo
-		FROM driver d INNER JOIN user u on d.userId = u.id
-		WHERE d.userId = ?`)
+	if !ensureSelfPathOr(w, r, reqId, PermReadAccounts) {
+		return
+	}
+
+	resp, err := fetchDriver(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		writeError(w, r, "DB err 1")
-		log.Println("getDriver: Error in prepare" + err.Error())
+		log.Println("getDriver: Error in query" + err.Error())
 		return
 	}
 
-	err = stmt.QueryRow(reqId).Scan(
-		&resp.Id, &resp.FirstName, &resp.LastName,
-		&resp.MobileNo, &resp.Email, &resp.IdentificationNo,
-		&resp.CarNo,
-	)
+	// Only the driver and reviewers see links to documents other than the
+	// photo
+	identity := callerIdentity(r)
+	all := hasPermission(identity, PermReviewDrivers) ||
+		identity.Role == RoleDriver && strconv.FormatInt(identity.UserId, 10) == reqId
+	resp.Documents, err = fetchDocuments(reqId, all)
 	if err != nil {
-		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		writeError(w, r, "DB err 2")
+		log.Println("getDriver: Error in query" + err.Error())
 		return
 	}
+	fillRatings("drivers", &resp)
 
 	json.NewEncoder(w).Encode(resp)
 }
@@ -330,48 +393,36 @@ type UpdateDriverInfo struct {
 }
 
 func updateDriver(w http.ResponseWriter, r *http.Request) {
-	var info UpdateDriverInfo
-	if ensureJson(w, r, &info) != nil {
-		return
-	}
-
 	reqId := mux.Vars(r)["id"]
 
-	stmt, err := db.Prepare(`UPDATE user u INNER JOIN driver d ON u.id = d.userId
-		SET firstName = ?, lastName = ?, mobileNo = ?, email = ?, carNo = ?
-		WHERE u.id = ?`)
-	if err != nil {
-		writeError(w, r, "DB err 1")
-		log.Println("updateDriver: Error in prepare" + err.Error())
+	if !ensureSelfPathOr(w, r, reqId, PermWriteAccounts) {
 		return
 	}
 
-	res, err := stmt.Exec(
-		info.FirstName, info.LastName, info.MobileNo,
-		info.Email, info.CarNo,
-		reqId,
-	)
-	if err != nil {
-		writeError(w, r, "DB err 2")
+	var info UpdateDriverInfo
+	if ensureJson(w, r, &info) != nil {
 		return
 	}
 
-	count, err := res.RowsAffected()
-	if err != nil {
-		writeError(w, r, "DB err 3")
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
 		return
 	}
 
-	// Check if any rows were updated
-	if count == 0 {
-		writeError(w, r, "No records were updated")
+	err := accounts.UpdateDriver(reqId, info, callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("updateDriver: Error in update" + err.Error())
 		return
 	}
 }
 
 func deleteDriver(w http.ResponseWriter, r *http.Request) {
-	_ = mux.Vars(r)["id"]
-	writeErrorStatus(w, r, "You cannot delete your account.", http.StatusForbidden)
+	reqId := mux.Vars(r)["id"]
+	deleteAccount(w, r, RoleDriver, reqId)
 }
 
 // --------------
@@ -381,21 +432,76 @@ func deleteDriver(w http.ResponseWriter, r *http.Request) {
 func registerEndpoints(db1 *sql.DB) *mux.Router {
 	// Set db object
 	db = db1
+	accounts = newAccountRepository(db)
 
 	// Register routes
 	router := mux.NewRouter()
+	secured := router.NewRoute().Subrouter()
+	secured.Use(authenticate)
+	admin := router.PathPrefix("/api/v1/admin").Subrouter()
+	admin.Use(authenticate)
 
 	router.HandleFunc("/api/v1", home)
 
-	router.HandleFunc("/api/v1/passengers", createPassenger).Methods("POST")
-	router.HandleFunc("/api/v1/passengers/{id}", getPassenger).Methods("GET")
-	router.HandleFunc("/api/v1/passengers/{id}", updatePassenger).Methods("PUT")
-	router.HandleFunc("/api/v1/passengers/{id}", deletePassenger).Methods("DELETE")
+	router.HandleFunc("/api/v1/auth/login", login).Methods("POST")
+	router.HandleFunc("/api/v1/auth/refresh", refreshSession).Methods("POST")
+	router.HandleFunc("/api/v1/auth/logout", logout).Methods("POST")
+	secured.HandleFunc("/api/v1/auth/session", getSession).Methods("GET")
+	router.HandleFunc("/api/v1/auth/password-reset", requestPasswordReset).Methods("POST")
+	router.HandleFunc("/api/v1/auth/password-reset/confirm", resetPassword).Methods("POST")
 
+	secured.HandleFunc("/api/v1/passengers", requirePermission(PermListAccounts, listPassengers)).Methods("GET")
+	router.HandleFunc("/api/v1/passengers", createPassenger).Methods("POST")
+	secured.HandleFunc("/api/v1/passengers/{id}", getPassenger).Methods("GET")
+	secured.HandleFunc("/api/v1/passengers/{id}", updatePassenger).Methods("PUT")
+	secured.HandleFunc("/api/v1/passengers/{id}", patchPassenger).Methods("PATCH")
+	secured.HandleFunc("/api/v1/passengers/{id}", deletePassenger).Methods("DELETE")
+
+	secured.HandleFunc("/api/v1/passengers/{id}/places", listPlaces).Methods("GET")
+	secured.HandleFunc("/api/v1/passengers/{id}/places", createPlace).Methods("POST")
+	secured.HandleFunc("/api/v1/passengers/{id}/places/{placeId}", getPlace).Methods("GET")
+	secured.HandleFunc("/api/v1/passengers/{id}/places/{placeId}", updatePlace).Methods("PUT")
+	secured.HandleFunc("/api/v1/passengers/{id}/places/{placeId}", deletePlace).Methods("DELETE")
+
+	secured.HandleFunc("/api/v1/drivers", requirePermission(PermListAccounts, listDrivers)).Methods("GET")
 	router.HandleFunc("/api/v1/drivers", createDriver).Methods("POST")
-	router.HandleFunc("/api/v1/drivers/{id}", getDriver).Methods("GET")
-	router.HandleFunc("/api/v1/drivers/{id}", updateDriver).Methods("PUT")
-	router.HandleFunc("/api/v1/drivers/{id}", deleteDriver).Methods("DELETE")
+	secured.HandleFunc("/api/v1/drivers/{id}", getDriver).Methods("GET")
+	secured.HandleFunc("/api/v1/drivers/{id}", updateDriver).Methods("PUT")
+	secured.HandleFunc("/api/v1/drivers/{id}", patchDriver).Methods("PATCH")
+	secured.HandleFunc("/api/v1/drivers/{id}", deleteDriver).Methods("DELETE")
+
+	secured.HandleFunc("/api/v1/users/{id}", getUser).Methods("GET")
+	secured.HandleFunc("/api/v1/users/{id}/passenger", addPassengerRole).Methods("POST")
+	secured.HandleFunc("/api/v1/users/{id}/driver", addDriverRole).Methods("POST")
+	secured.HandleFunc("/api/v1/users/{id}/verifications", requestVerification).Methods("POST")
+	secured.HandleFunc("/api/v1/users/{id}/verifications/confirm", confirmVerification).Methods("POST")
+
+	// Driver onboarding
+	secured.HandleFunc("/api/v1/drivers/{id}/vehicles", listVehicles).Methods("GET")
+	secured.HandleFunc("/api/v1/drivers/{id}/vehicles/active", getActiveVehicle).Methods("GET")
+	secured.HandleFunc("/api/v1/drivers/{id}/vehicles", createVehicle).Methods("POST")
+	secured.HandleFunc("/api/v1/drivers/{id}/vehicles/{vehicleId}", updateVehicle).Methods("PUT")
+	secured.HandleFunc("/api/v1/drivers/{id}/vehicles/{vehicleId}", deleteVehicle).Methods("DELETE")
+	secured.HandleFunc("/api/v1/drivers/{id}/vehicles/{vehicleId}/activate", setActiveVehicle).Methods("POST")
+	secured.HandleFunc("/api/v1/drivers/{id}/documents", listDocuments).Methods("GET")
+	secured.HandleFunc("/api/v1/drivers/{id}/documents/{type}", uploadDocument).Methods("PUT")
+	secured.HandleFunc("/api/v1/drivers/{id}/documents/{type}", deleteDocument).Methods("DELETE")
+	router.HandleFunc("/api/v1/documents/{id}/file", downloadDocument).Methods("GET")
+
+	secured.HandleFunc("/api/v1/users/{id}/audit", requirePermission(PermReadAudit, getUserAudit)).Methods("GET")
+
+	admin.HandleFunc("/passengers/import", requirePermission(PermWriteAccounts, importPassengers)).Methods("POST")
+	admin.HandleFunc("/passengers/export", requirePermission(PermListAccounts, exportPassengers)).Methods("GET")
+	admin.HandleFunc("/drivers/import", requirePermission(PermWriteAccounts, importDrivers)).Methods("POST")
+	admin.HandleFunc("/drivers/export", requirePermission(PermListAccounts, exportDrivers)).Methods("GET")
+	admin.HandleFunc("/drivers/{id}/documents", requirePermission(PermReviewDrivers, listDocuments)).Methods("GET")
+	admin.HandleFunc("/drivers/{id}/approve", requirePermission(PermManageDrivers, approveDriver)).Methods("POST")
+	admin.HandleFunc("/drivers/{id}/suspend", requirePermission(PermManageDrivers, suspendDriver)).Methods("POST")
+	admin.HandleFunc("/drivers/{id}/reinstate", requirePermission(PermManageDrivers, reinstateDriver)).Methods("POST")
+	admin.HandleFunc("/users/{id}/staff", requirePermission(PermManageStaff, grantStaffRole)).Methods("PUT")
+	admin.HandleFunc("/users/{id}/staff", requirePermission(PermManageStaff, revokeStaffRole)).Methods("DELETE")
+	admin.HandleFunc("/users/{id}/suspension", requirePermission(PermSuspendAccounts, suspendUser)).Methods("PUT")
+	admin.HandleFunc("/users/{id}/suspension", requirePermission(PermSuspendAccounts, unsuspendUser)).Methods("DELETE")
 
 	return router
 }
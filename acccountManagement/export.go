@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Exported columns, in the same format as imports. Passwords cannot be
+// exported, so exports must be given passwords before being imported again.
+type accountExport struct {
+	header  []string
+	columns string
+	from    string
+	// Scans a row, returning the value for NDJSON and the record for CSV
+	scan func(rows *sql.Rows) (interface{}, []string, error)
+}
+
+type ExportedPassenger struct {
+	Id             int64  `json:"id"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	MobileNo       string `json:"mobileNo"`
+	Email          string `json:"email"`
+	EmailVerified  bool   `json:"emailVerified"`
+	MobileVerified bool   `json:"mobileVerified"`
+}
+
+func (p ExportedPassenger) record() []string {
+	return []string{
+		strconv.FormatInt(p.Id, 10), p.FirstName, p.LastName, p.MobileNo, p.Email,
+		strconv.FormatBool(p.EmailVerified), strconv.FormatBool(p.MobileVerified),
+	}
+}
+
+type ExportedDriver struct {
+	ExportedPassenger
+	IdentificationNo string `json:"identificationNo"`
+	CarNo            string `json:"carNo"`
+	Status           string `json:"status"`
+}
+
+// The last line of an NDJSON export that could not be finished
+type ExportError struct {
+	Error string `json:"error"`
+}
+
+// Writes every account that is not deleted, ordered by id, as CSV or with
+// format=ndjson as newline delimited JSON
+func exportAccounts(w http.ResponseWriter, r *http.Request, export accountExport) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "csv" && format != "ndjson" {
+		writeValidationErrors(w, r, []FieldError{{Field: "format", Message: "must be csv or ndjson"}})
+		return
+	}
+
+	rows, err := db.Query("SELECT " + export.columns + " FROM " + export.from + " WHERE u.deletedAt IS NULL ORDER BY u.id")
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("exportAccounts: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	// Rows are streamed, so errors after this cannot change the status
+	var csvWriter *csv.Writer
+	var jsonEncoder *json.Encoder
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", contentTypeNdjson)
+		jsonEncoder = json.NewEncoder(w)
+	} else {
+		w.Header().Set("Content-Type", contentTypeCsv)
+		csvWriter = csv.NewWriter(w)
+		csvWriter.Write(export.header)
+	}
+
+	var readErr error
+	for rows.Next() {
+		value, record, err := export.scan(rows)
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		if jsonEncoder != nil {
+			err = jsonEncoder.Encode(value)
+		} else {
+			err = csvWriter.Write(record)
+		}
+		if err != nil {
+			log.Println("exportAccounts: Error in write" + err.Error())
+			return
+		}
+	}
+	if readErr == nil {
+		readErr = rows.Err()
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	if readErr == nil {
+		return
+	}
+
+	// The client must not mistake a partial export for a complete one. NDJSON
+	// ends with an error line, and CSV has no room for one, so the response is
+	// aborted instead.
+	log.Println("exportAccounts: Error in rows" + readErr.Error())
+	if jsonEncoder != nil {
+		jsonEncoder.Encode(ExportError{Error: "Export could not be finished"})
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func exportPassengers(w http.ResponseWriter, r *http.Request) {
+	exportAccounts(w, r, accountExport{
+		header:  []string{"id", "firstName", "lastName", "mobileNo", "email", "emailVerified", "mobileVerified"},
+		columns: "u.id, u.firstName, u.lastName, u.mobileNo, u.email, " + verifiedColumns,
+		from:    "passenger p INNER JOIN user u ON p.userId = u.id",
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var p ExportedPassenger
+			err := rows.Scan(
+				&p.Id, &p.FirstName, &p.LastName, &p.MobileNo, &p.Email,
+				&p.EmailVerified, &p.MobileVerified,
+			)
+			return p, p.record(), err
+		},
+	})
+}
+
+func exportDrivers(w http.ResponseWriter, r *http.Request) {
+	exportAccounts(w, r, accountExport{
+		header: []string{
+			"id", "firstName", "lastName", "mobileNo", "email", "emailVerified", "mobileVerified",
+			"identificationNo", "carNo", "status",
+		},
+		columns: "u.id, u.firstName, u.lastName, u.mobileNo, u.email, " + verifiedColumns + ", d.identificationNo, d.carNo, d.status",
+		from:    "driver d INNER JOIN user u ON d.userId = u.id",
+		scan: func(rows *sql.Rows) (interface{}, []string, error) {
+			var d ExportedDriver
+			err := rows.Scan(
+				&d.Id, &d.FirstName, &d.LastName, &d.MobileNo, &d.Email,
+				&d.EmailVerified, &d.MobileVerified,
+				&d.IdentificationNo, &d.CarNo, &d.Status,
+			)
+			return d, append(d.record(), d.IdentificationNo, d.CarNo, d.Status), err
+		},
+	})
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Most rows an import may have, and the largest accepted body
+const maxImportRows = 1000
+const maxImportSize = 5 << 20
+
+// Accepted content types of an import
+const (
+	contentTypeCsv    = "text/csv"
+	contentTypeNdjson = "application/x-ndjson"
+)
+
+// Returned from an import transaction to roll it back without failing
+var errImportRolledBack = errors.New("import rolled back")
+
+// An account in an import, i.e. a CreatePassengerInfo or CreateDriverInfo
+type importedAccount interface {
+	validate() []FieldError
+	password() string
+	insert(tx *sql.Tx, passwordHash string) (int64, error)
+}
+
+func (info *CreatePassengerInfo) password() string {
+	return info.Password
+}
+
+func (info *CreatePassengerInfo) insert(tx *sql.Tx, passwordHash string) (int64, error) {
+	return accounts.insertPassenger(tx, *info, passwordHash)
+}
+
+func (info *CreateDriverInfo) password() string {
+	return info.Password
+}
+
+func (info *CreateDriverInfo) insert(tx *sql.Tx, passwordHash string) (int64, error) {
+	return accounts.insertDriver(tx, *info, passwordHash)
+}
+
+// A row of an import. Line is the line number in the file.
+type importRow struct {
+	line    int
+	account importedAccount
+	errors  []FieldError
+}
+
+// Reads the rows of a CSV file with a header row. Columns are named like
+// the JSON fields, and unknown columns are ignored. Rows that cannot be
+// parsed or do not match the header get an error instead.
+func readCsvRows(body io.Reader, newAccount func() importedAccount) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{
+				line:    parseErr.StartLine,
+				account: newAccount(),
+				errors:  []FieldError{{Field: "row", Message: "could not be read: " + parseErr.Err.Error()}},
+			})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := importRow{account: newAccount()}
+		row.line, _ = reader.FieldPos(0)
+
+		values := map[string]string{}
+		for i, column := range header {
+			if i >= len(record) {
+				row.errors = append(row.errors, FieldError{Field: column, Message: "is missing from the row"})
+				continue
+			}
+			values[column] = record[i]
+		}
+		if len(record) > len(header) {
+			row.errors = append(row.errors, FieldError{Field: "row", Message: "has more values than the header"})
+		}
+		if len(row.errors) > 0 {
+			rows = append(rows, row)
+			continue
+		}
+
+		// Fields are decoded the same way as JSON requests
+		data, _ := json.Marshal(values)
+		if err = json.Unmarshal(data, row.account); err != nil {
+			row.errors = []FieldError{{Field: "row", Message: "could not be read"}}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Reads the rows of newline delimited JSON. Blank lines are skipped.
+func readNdjsonRows(body io.Reader, newAccount func() importedAccount) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+
+	var rows []importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		row := importRow{line: line, account: newAccount()}
+		if err := json.Unmarshal(scanner.Bytes(), row.account); err != nil {
+			row.errors = []FieldError{{Field: "row", Message: "is not valid JSON"}}
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// Inserts every row in a single transaction. Rows that clash with existing
+// accounts or earlier rows get an error. Nothing is kept if a row has an
+// error or this is a dry run.
+func (repo *accountRepository) ImportAccounts(rows []importRow, passwordHashes []string, dryRun bool) ([]int64, error) {
+	var ids []int64
+	err := repo.transaction(func(tx *sql.Tx) error {
+		failed := false
+		for i := range rows {
+			row := &rows[i]
+			if len(row.errors) > 0 {
+				failed = true
+				continue
+			}
+
+			id, err := row.account.insert(tx, passwordHashes[i])
+			var duplicate *DuplicateError
+			if errors.As(err, &duplicate) {
+				row.errors = append(row.errors, FieldError{Field: duplicate.Field, Message: "is already in use"})
+				failed = true
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		if failed || dryRun {
+			return errImportRolledBack
+		}
+		return nil
+	})
+	if err == errImportRolledBack {
+		return nil, nil
+	}
+	return ids, err
+}
+
+// Hashes the passwords of the rows, on as many goroutines as there are CPUs
+func hashImportPasswords(rows []importRow) ([]string, error) {
+	hashes := make([]string, len(rows))
+	errs := make([]error, len(rows))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < runtime.NumCPU(); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hashes[i], errs[i] = hashPassword(rows[i].account.password())
+			}
+		}()
+	}
+	for i := range rows {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// --------------
+
+type ImportRowError struct {
+	Line   int          `json:"line"`
+	Errors []FieldError `json:"errors"`
+}
+
+type ImportResponse struct {
+	Status      bool   `json:"status"`
+	Description string `json:"description"`
+	DryRun      bool   `json:"dryRun"`
+	// Number of valid rows, which are created unless this is a dry run
+	Rows   int              `json:"rows"`
+	Ids    []int64          `json:"ids"`
+	Errors []ImportRowError `json:"errors"`
+}
+
+// Imports accounts from a CSV or NDJSON body. With the dryRun query
+// parameter, the rows are checked without creating any account.
+func importAccounts(w http.ResponseWriter, r *http.Request, newAccount func() importedAccount) {
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var rows []importRow
+	var err error
+	switch mediaType {
+	case contentTypeCsv:
+		rows, err = readCsvRows(body, newAccount)
+	case contentTypeNdjson:
+		rows, err = readNdjsonRows(body, newAccount)
+	default:
+		writeError(w, r, "Expected Content-type = "+contentTypeCsv+" or "+contentTypeNdjson)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "Could not read import: "+err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		writeError(w, r, "Import has no rows")
+		return
+	}
+	if len(rows) > maxImportRows {
+		writeError(w, r, "Import has more than "+strconv.Itoa(maxImportRows)+" rows")
+		return
+	}
+
+	failed := false
+	for i := range rows {
+		row := &rows[i]
+		if len(row.errors) == 0 {
+			row.errors = row.account.validate()
+		}
+		failed = failed || len(row.errors) > 0
+	}
+
+	// Hashing is slow, so it is skipped when nothing will be kept
+	passwordHashes := make([]string, len(rows))
+	if !failed && !dryRun {
+		passwordHashes, err = hashImportPasswords(rows)
+		if err != nil {
+			writeErrorStatus(w, r, "Could not hash password", http.StatusInternalServerError)
+			log.Println("importAccounts: Error in hash" + err.Error())
+			return
+		}
+	}
+
+	ids, err := accounts.ImportAccounts(rows, passwordHashes, dryRun)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("importAccounts: Error in import" + err.Error())
+		return
+	}
+
+	resp := ImportResponse{
+		Status: true,
+		DryRun: dryRun,
+		Ids:    []int64{},
+		Errors: []ImportRowError{},
+	}
+	if ids != nil {
+		resp.Ids = ids
+	}
+	for _, row := range rows {
+		if len(row.errors) > 0 {
+			resp.Errors = append(resp.Errors, ImportRowError{Line: row.line, Errors: row.errors})
+		} else {
+			resp.Rows++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Errors) > 0 {
+		resp.Status = false
+		resp.Description = "One or more rows are invalid, nothing was imported"
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func importPassengers(w http.ResponseWriter, r *http.Request) {
+	importAccounts(w, r, func() importedAccount {
+		return &CreatePassengerInfo{}
+	})
+}
+
+func importDrivers(w http.ResponseWriter, r *http.Request) {
+	importAccounts(w, r, func() importedAccount {
+		return &CreateDriverInfo{}
+	})
+}
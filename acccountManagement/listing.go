@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultListLimit = 20
+const maxListLimit = 100
+
+// Columns that accounts can be sorted by, by query value
+var listSortColumns = map[string]string{
+	"id":        "u.id",
+	"firstName": "u.firstName",
+	"lastName":  "u.lastName",
+	"email":     "u.email",
+}
+
+// Position after the last item of a page. Encoded as base64 JSON so clients
+// treat it as opaque. The sort and order it was made for are kept, as the
+// position means nothing in another order.
+type listCursor struct {
+	Value string `json:"v"`
+	Id    int64  `json:"id"`
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+}
+
+func encodeCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (listCursor, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	return cursor, err
+}
+
+// Gets the page size from the limit query parameter, or the default if empty
+func parseListLimit(v *validator, value string) int {
+	if value == "" {
+		return defaultListLimit
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxListLimit {
+		v.fail("limit", "must be from 1 to "+strconv.Itoa(maxListLimit))
+	}
+	return n
+}
+
+type ListResponse struct {
+	Items      []interface{} `json:"items"`
+	Total      int64         `json:"total"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// Describes how to list the accounts of a role
+type accountListing struct {
+	// Selected columns, and the tables they come from
+	columns string
+	from    string
+	// Exact match filters, from query parameter to column
+	filters map[string]string
+	// Scans a row after the sort value, returning the item and its user id
+	scan func(rows *sql.Rows, sortValue *string) (ratedAccount, int64, error)
+	// Group of accounts the items' ratings are of
+	ratingGroup string
+}
+
+// Writes a page of accounts, filtered and sorted by the query parameters:
+// limit, cursor, sort, order, name (prefix of first or last name) and the
+// listing's filters
+func writeAccountList(w http.ResponseWriter, r *http.Request, listing accountListing) {
+	query := r.URL.Query()
+
+	var v validator
+
+	limit := parseListLimit(&v, query.Get("limit"))
+
+	sortName := query.Get("sort")
+	if sortName == "" {
+		sortName = "id"
+	}
+	sortColumn, ok := listSortColumns[sortName]
+	if !ok {
+		v.fail("sort", "is not a sortable field")
+	}
+
+	orderName := query.Get("order")
+	if orderName == "" {
+		orderName = "asc"
+	}
+	descending := false
+	switch orderName {
+	case "asc":
+	case "desc":
+		descending = true
+	default:
+		v.fail("order", "must be asc or desc")
+	}
+
+	var cursor *listCursor
+	if value := query.Get("cursor"); value != "" {
+		c, err := decodeCursor(value)
+		if err != nil {
+			v.fail("cursor", "is not valid")
+		} else if c.Sort != sortName || c.Order != orderName {
+			v.fail("cursor", "is for a different sort or order")
+		}
+		cursor = &c
+	}
+
+	if len(v.errors) > 0 {
+		writeValidationErrors(w, r, v.errors)
+		return
+	}
+
+	// Filters, which also apply to the total count
+	conditions := []string{"u.deletedAt IS NULL"}
+	args := []interface{}{}
+	if name := query.Get("name"); name != "" {
+		conditions = append(conditions, "(u.firstName LIKE ? OR u.lastName LIKE ?)")
+		prefix := escapeLike(name) + "%"
+		args = append(args, prefix, prefix)
+	}
+	for param, column := range listing.filters {
+		if value := query.Get(param); value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+
+	var total int64
+	err := db.QueryRow("SELECT COUNT(*) FROM "+listing.from+" WHERE "+strings.Join(conditions, " AND "), args...).Scan(&total)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("writeAccountList: Error in count" + err.Error())
+		return
+	}
+
+	// Continue after the cursor, with the id breaking ties
+	compare, order := ">", "ASC"
+	if descending {
+		compare, order = "<", "DESC"
+	}
+	if cursor != nil {
+		if sortColumn == "u.id" {
+			conditions = append(conditions, "u.id "+compare+" ?")
+			args = append(args, cursor.Id)
+		} else {
+			conditions = append(conditions, "("+sortColumn+" "+compare+" ? OR ("+sortColumn+" = ? AND u.id "+compare+" ?))")
+			args = append(args, cursor.Value, cursor.Value, cursor.Id)
+		}
+	}
+
+	// Fetch an extra row to know if there is a next page
+	rows, err := db.Query("SELECT "+sortColumn+", "+listing.columns+
+		" FROM "+listing.from+
+		" WHERE "+strings.Join(conditions, " AND ")+
+		" ORDER BY "+sortColumn+" "+order+", u.id "+order+
+		" LIMIT "+strconv.Itoa(limit+1), args...)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("writeAccountList: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := ListResponse{
+		Items: []interface{}{},
+		Total: total,
+	}
+	var items []ratedAccount
+	last := listCursor{Sort: sortName, Order: orderName}
+	for rows.Next() {
+		// The extra row means the next page continues after the last item
+		if len(resp.Items) == limit {
+			resp.NextCursor = encodeCursor(last)
+			break
+		}
+
+		item, id, err := listing.scan(rows, &last.Value)
+		if err != nil {
+			writeError(w, r, "DB err 3")
+			log.Println("writeAccountList: Error in scan" + err.Error())
+			return
+		}
+		last.Id = id
+		resp.Items = append(resp.Items, item)
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		writeError(w, r, "DB err 4")
+		log.Println("writeAccountList: Error in rows" + err.Error())
+		return
+	}
+
+	fillRatings(listing.ratingGroup, items...)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Escapes the wildcards of a LIKE pattern
+func escapeLike(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "%", `\%`)
+	return strings.ReplaceAll(value, "_", `\_`)
+}
+
+// --------------
+
+func listPassengers(w http.ResponseWriter, r *http.Request) {
+	writeAccountList(w, r, accountListing{
+		columns: "u.id, u.firstName, u.lastName, u.mobileNo, u.email, " + verifiedColumns + ", " + suspensionColumns,
+		from:    "passenger p INNER JOIN user u ON p.userId = u.id" + suspensionJoin,
+		filters: map[string]string{
+			"email":    "u.email",
+			"mobileNo": "u.mobileNo",
+		},
+		scan: func(rows *sql.Rows, sortValue *string) (ratedAccount, int64, error) {
+			var p GetPassengerResponse
+			var suspension nullSuspension
+			err := rows.Scan(sortValue,
+				&p.Id, &p.FirstName, &p.LastName, &p.MobileNo, &p.Email,
+				&p.EmailVerified, &p.MobileVerified,
+				&suspension.Reason, &suspension.ExpiresAt, &suspension.SuspendedAt,
+			)
+			p.Suspension = suspension.info()
+			return &p, p.Id, err
+		},
+		ratingGroup: "passengers",
+	})
+}
+
+func listDrivers(w http.ResponseWriter, r *http.Request) {
+	writeAccountList(w, r, accountListing{
+		columns: "u.id, u.firstName, u.lastName, u.mobileNo, u.email, " + verifiedColumns + ", d.identificationNo, d.carNo, d.status, " + suspensionColumns,
+		from:    "driver d INNER JOIN user u ON d.userId = u.id" + suspensionJoin,
+		filters: map[string]string{
+			"email":    "u.email",
+			"mobileNo": "u.mobileNo",
+			"carNo":    "d.carNo",
+			"status":   "d.status",
+		},
+		scan: func(rows *sql.Rows, sortValue *string) (ratedAccount, int64, error) {
+			var d GetDriverResponse
+			var suspension nullSuspension
+			err := rows.Scan(sortValue,
+				&d.Id, &d.FirstName, &d.LastName,
+				&d.MobileNo, &d.Email,
+				&d.EmailVerified, &d.MobileVerified,
+				&d.IdentificationNo, &d.CarNo, &d.Status,
+				&suspension.Reason, &suspension.ExpiresAt, &suspension.SuspendedAt,
+			)
+			d.Suspension = suspension.info()
+			return &d, d.Id, err
+		},
+		ratingGroup: "drivers",
+	})
+}
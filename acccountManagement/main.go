@@ -13,6 +13,10 @@ import (
 const PORT = 21801
 
 func main() {
+	loadTokenSecret()
+	loadSender()
+	loadBlobStore()
+
 	db, err := sql.Open("mysql", "root@tcp(127.0.0.1:3306)/etia1account")
 
 	// handle error
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Profile fields that can be changed with a merge patch. The JSON names are
+// also the column names.
+var passengerPatchFields = []string{"firstName", "lastName", "mobileNo", "email"}
+var driverPatchFields = []string{"firstName", "lastName", "mobileNo", "email", "carNo"}
+
+// Ensures that the request is a JSON merge patch (RFC 7396) and reads it. The
+// raw body is returned for applying onto a structure.
+func ensureMergePatch(w http.ResponseWriter, r *http.Request) (map[string]json.RawMessage, []byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		writeError(w, r, "Expected Content-type = application/merge-patch+json")
+		return nil, nil, errors.New("Expected Content-type = application/merge-patch+json")
+	}
+
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, "Could not read request body")
+		return nil, nil, err
+	}
+
+	// A merge patch that is not an object would replace the whole resource
+	var patch map[string]json.RawMessage
+	err = json.Unmarshal(reqBody, &patch)
+	if err != nil || patch == nil {
+		writeError(w, r, "Expected a JSON object")
+		return nil, nil, errors.New("Expected a JSON object")
+	}
+
+	return patch, reqBody, nil
+}
+
+// Checks that the patch only sets fields which may be changed. Every profile
+// field is required, so none may be removed with null.
+func checkPatchFields(patch map[string]json.RawMessage, allowed []string) []FieldError {
+	var v validator
+	for field, value := range patch {
+		if !containsString(allowed, field) {
+			v.fail(field, "cannot be changed")
+		} else if string(value) == "null" {
+			v.fail(field, "cannot be removed")
+		}
+	}
+	return v.errors
+}
+
+// Gets the allowed fields set by the patch, in a stable order
+func patchedFields(patch map[string]json.RawMessage, allowed []string) []string {
+	var fields []string
+	for _, field := range allowed {
+		if _, ok := patch[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Keeps only errors of fields set by the patch, so existing values are not
+// checked again
+func patchedFieldErrors(fieldErrors []FieldError, patch map[string]json.RawMessage) []FieldError {
+	var result []FieldError
+	for _, fieldError := range fieldErrors {
+		if _, ok := patch[fieldError.Field]; ok {
+			result = append(result, fieldError)
+		}
+	}
+	return result
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// --------------
+
+func patchPassenger(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	if !ensureSelfPathOr(w, r, reqId, PermWriteAccounts) {
+		return
+	}
+
+	patch, reqBody, err := ensureMergePatch(w, r)
+	if err != nil {
+		return
+	}
+
+	if fieldErrors := checkPatchFields(patch, passengerPatchFields); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	current, err := fetchPassenger(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("patchPassenger: Error in query" + err.Error())
+		return
+	}
+
+	// Apply the patch onto the current profile
+	info := UpdatePassengerInfo{
+		FirstName: current.FirstName,
+		LastName:  current.LastName,
+		MobileNo:  current.MobileNo,
+		Email:     current.Email,
+	}
+	err = json.Unmarshal(reqBody, &info)
+	if err != nil {
+		writeError(w, r, "Could not decode request JSON: "+err.Error())
+		return
+	}
+
+	if fieldErrors := patchedFieldErrors(info.validate(), patch); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	columns := patchedFields(patch, passengerPatchFields)
+	if len(columns) > 0 {
+		err = accounts.UpdateColumns(RolePassenger, reqId, columns, map[string]string{
+			"firstName": info.FirstName,
+			"lastName":  info.LastName,
+			"mobileNo":  info.MobileNo,
+			"email":     info.Email,
+		}, callerIdentity(r))
+		if writeAccountError(w, r, reqId, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, r, "DB err 2")
+			log.Println("patchPassenger: Error in update" + err.Error())
+			return
+		}
+	}
+
+	resp, err := fetchPassenger(reqId)
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("patchPassenger: Error in query" + err.Error())
+		return
+	}
+
+	fillRatings("passengers", &resp)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func patchDriver(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	if !ensureSelfPathOr(w, r, reqId, PermWriteAccounts) {
+		return
+	}
+
+	patch, reqBody, err := ensureMergePatch(w, r)
+	if err != nil {
+		return
+	}
+
+	if fieldErrors := checkPatchFields(patch, driverPatchFields); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	current, err := fetchDriver(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("patchDriver: Error in query" + err.Error())
+		return
+	}
+
+	// Apply the patch onto the current profile
+	info := UpdateDriverInfo{
+		FirstName: current.FirstName,
+		LastName:  current.LastName,
+		MobileNo:  current.MobileNo,
+		Email:     current.Email,
+		CarNo:     current.CarNo,
+	}
+	err = json.Unmarshal(reqBody, &info)
+	if err != nil {
+		writeError(w, r, "Could not decode request JSON: "+err.Error())
+		return
+	}
+
+	if fieldErrors := patchedFieldErrors(info.validate(), patch); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	columns := patchedFields(patch, driverPatchFields)
+	if len(columns) > 0 {
+		err = accounts.UpdateColumns(RoleDriver, reqId, columns, map[string]string{
+			"firstName": info.FirstName,
+			"lastName":  info.LastName,
+			"mobileNo":  info.MobileNo,
+			"email":     info.Email,
+			"carNo":     info.CarNo,
+		}, callerIdentity(r))
+		if writeAccountError(w, r, reqId, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, r, "DB err 2")
+			log.Println("patchDriver: Error in update" + err.Error())
+			return
+		}
+	}
+
+	resp, err := fetchDriver(reqId)
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("patchDriver: Error in query" + err.Error())
+		return
+	}
+
+	fillRatings("drivers", &resp)
+
+	json.NewEncoder(w).Encode(resp)
+}
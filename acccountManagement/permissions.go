@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Staff roles, which a user holds in addition to being a passenger or driver
+const (
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Role of tokens other services issue to call on their own behalf
+const RoleService = "service"
+
+// Permissions to act on resources other than the caller's own
+const (
+	PermReadAccounts    = "accounts:read"
+	PermListAccounts    = "accounts:list"
+	PermWriteAccounts   = "accounts:write"
+	PermSuspendAccounts = "accounts:suspend"
+	PermReviewDrivers   = "drivers:review"
+	PermManageDrivers   = "drivers:manage"
+	PermReadAudit       = "audit:read"
+	PermManageStaff     = "staff:manage"
+	PermReadTrips       = "trips:read"
+	PermManageTrips     = "trips:manage"
+	PermReadHistory     = "history:read"
+	PermWriteHistory    = "history:write"
+)
+
+// Permissions of each role. Passengers and drivers have none, they may only
+// act on their own resources. Must be the same in every service.
+var rolePermissions = map[string][]string{
+	RoleSupport: {
+		PermReadAccounts, PermListAccounts, PermSuspendAccounts, PermReviewDrivers,
+		PermReadAudit, PermReadTrips, PermReadHistory,
+	},
+	RoleAdmin: {
+		PermReadAccounts, PermListAccounts, PermWriteAccounts, PermSuspendAccounts,
+		PermReviewDrivers, PermManageDrivers, PermReadAudit, PermManageStaff,
+		PermReadTrips, PermManageTrips, PermReadHistory,
+	},
+	RoleService: {
+		PermReadAccounts, PermWriteHistory,
+	},
+}
+
+var staffRoles = []string{RoleSupport, RoleAdmin}
+
+// Checks whether the caller's role grants the permission
+func hasPermission(identity Identity, permission string) bool {
+	return containsString(rolePermissions[identity.Role], permission)
+}
+
+// Middleware for a single route that only allows callers with the
+// permission. Must run after authenticate.
+func requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(callerIdentity(r), permission) {
+			writeErrorStatus(w, r, "You do not have permission to do this", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Same as ensureSelfPath, but callers with the permission may act on any user
+func ensureSelfPathOr(w http.ResponseWriter, r *http.Request, reqId string, permission string) bool {
+	if hasPermission(callerIdentity(r), permission) {
+		return true
+	}
+	return ensureSelfPath(w, r, reqId)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Most places a passenger may save
+const maxSavedPlaces = 20
+
+// Maximum length of the notes of a place
+const maxPlaceNotesLength = 255
+
+// Labels with a meaning to clients. Any other label may be used too.
+const (
+	PlaceHome = "home"
+	PlaceWork = "work"
+)
+
+type PlaceInfo struct {
+	Id         int64  `json:"id"`
+	Label      string `json:"label"`
+	PostalCode string `json:"postalCode"`
+	UnitNo     string `json:"unitNo"`
+	Notes      string `json:"notes"`
+}
+
+func (info *PlaceInfo) validate() []FieldError {
+	info.Label = normalizeText(info.Label)
+	info.PostalCode = normalizeIdentifier(info.PostalCode)
+	info.UnitNo = normalizeIdentifier(info.UnitNo)
+	info.Notes = normalizeText(info.Notes)
+
+	var v validator
+	v.required("label", info.Label)
+	v.postalCode("postalCode", info.PostalCode)
+	v.optional("unitNo", info.UnitNo, maxFieldLength)
+	v.optional("notes", info.Notes, maxPlaceNotesLength)
+	return v.errors
+}
+
+const placeColumns = "id, label, postalCode, unitNo, notes"
+
+func scanPlace(row interface{ Scan(...interface{}) error }, info *PlaceInfo) error {
+	return row.Scan(&info.Id, &info.Label, &info.PostalCode, &info.UnitNo, &info.Notes)
+}
+
+// Checks that the signed in passenger exists. Returns whether to continue.
+func ensureSelfPassenger(w http.ResponseWriter, r *http.Request, passengerId string) bool {
+	if !ensureCallerPath(w, r, RolePassenger, passengerId) {
+		return false
+	}
+
+	_, err := fetchPassenger(passengerId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+passengerId, http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("ensureSelfPassenger: Error in query" + err.Error())
+		return false
+	}
+	return true
+}
+
+// --------------
+
+type ListPlacesResponse struct {
+	Places []PlaceInfo `json:"places"`
+}
+
+func listPlaces(w http.ResponseWriter, r *http.Request) {
+	passengerId := mux.Vars(r)["id"]
+
+	if !ensureSelfPassenger(w, r, passengerId) {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+placeColumns+" FROM saved_place WHERE passengerId = ? ORDER BY label", passengerId)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("listPlaces: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := ListPlacesResponse{
+		Places: []PlaceInfo{},
+	}
+	for rows.Next() {
+		var info PlaceInfo
+		if err = scanPlace(rows, &info); err != nil {
+			writeError(w, r, "DB err 3")
+			log.Println("listPlaces: Error in scan" + err.Error())
+			return
+		}
+		resp.Places = append(resp.Places, info)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getPlace(w http.ResponseWriter, r *http.Request) {
+	passengerId := mux.Vars(r)["id"]
+	placeId := mux.Vars(r)["placeId"]
+
+	if !ensureSelfPassenger(w, r, passengerId) {
+		return
+	}
+
+	var info PlaceInfo
+	err := scanPlace(db.QueryRow("SELECT "+placeColumns+" FROM saved_place WHERE id = ? AND passengerId = ?", placeId, passengerId), &info)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Place not found: "+placeId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("getPlace: Error in query" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+func createPlace(w http.ResponseWriter, r *http.Request) {
+	passengerId := mux.Vars(r)["id"]
+
+	var info PlaceInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	if !ensureSelfPassenger(w, r, passengerId) {
+		return
+	}
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM saved_place WHERE passengerId = ?", passengerId).Scan(&count)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("createPlace: Error in query" + err.Error())
+		return
+	}
+	if count >= maxSavedPlaces {
+		writeErrorStatus(w, r, "You have saved too many places. Please remove one first.", http.StatusConflict)
+		return
+	}
+
+	res, err := db.Exec(`INSERT INTO saved_place
+		(passengerId, label, postalCode, unitNo, notes)
+		VALUES (?, ?, ?, ?, ?)`,
+		passengerId, info.Label, info.PostalCode, info.UnitNo, info.Notes)
+	if field, ok := duplicateField(err); ok {
+		writeConflict(w, r, field)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("createPlace: Error in exec" + err.Error())
+		return
+	}
+
+	info.Id, err = res.LastInsertId()
+	if err != nil {
+		writeError(w, r, "DB err 4")
+		log.Println("createPlace: Error in last id" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+func updatePlace(w http.ResponseWriter, r *http.Request) {
+	passengerId := mux.Vars(r)["id"]
+	placeId := mux.Vars(r)["placeId"]
+
+	var info PlaceInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	if !ensureSelfPassenger(w, r, passengerId) {
+		return
+	}
+
+	_, err := db.Exec(`UPDATE saved_place
+		SET label = ?, postalCode = ?, unitNo = ?, notes = ?
+		WHERE id = ? AND passengerId = ?`,
+		info.Label, info.PostalCode, info.UnitNo, info.Notes,
+		placeId, passengerId)
+	if field, ok := duplicateField(err); ok {
+		writeConflict(w, r, field)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("updatePlace: Error in exec" + err.Error())
+		return
+	}
+
+	getPlace(w, r)
+}
+
+func deletePlace(w http.ResponseWriter, r *http.Request) {
+	passengerId := mux.Vars(r)["id"]
+	placeId := mux.Vars(r)["placeId"]
+
+	if !ensureSelfPassenger(w, r, passengerId) {
+		return
+	}
+
+	res, err := db.Exec("DELETE FROM saved_place WHERE id = ? AND passengerId = ?", placeId, passengerId)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("deletePlace: Error in exec" + err.Error())
+		return
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		return
+	}
+	if count == 0 {
+		writeErrorStatus(w, r, "Place not found: "+placeId, http.StatusNotFound)
+		return
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Ratings a user received from the other side of their trips, as summarized
+// by tripHistory. Rating is null without ratings.
+type UserRating struct {
+	Rating      *float64 `json:"rating"`
+	RatingCount int64    `json:"ratingCount"`
+}
+
+func (rating *UserRating) setRating(value UserRating) {
+	*rating = value
+}
+
+// An account response which includes the user's rating
+type ratedAccount interface {
+	accountId() int64
+	setRating(value UserRating)
+}
+
+func (resp *GetPassengerResponse) accountId() int64 {
+	return resp.Id
+}
+
+func (resp *GetDriverResponse) accountId() int64 {
+	return resp.Id
+}
+
+// Gets the ratings of drivers or passengers, as given by group, from
+// tripHistory
+func fetchRatings(group string, ids []int64) (map[string]UserRating, error) {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.FormatInt(id, 10)
+	}
+
+	resp, err := serviceClient.Get(tripHistoryApiUrl + "/api/v1/ratings/" + group + "?ids=" + strings.Join(values, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status from tripHistory: " + resp.Status)
+	}
+
+	var summaries struct {
+		Ratings map[string]struct {
+			Average *float64 `json:"average"`
+			Count   int64    `json:"count"`
+		} `json:"ratings"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&summaries)
+	if err != nil {
+		return nil, err
+	}
+
+	ratings := map[string]UserRating{}
+	for id, summary := range summaries.Ratings {
+		ratings[id] = UserRating{
+			Rating:      summary.Average,
+			RatingCount: summary.Count,
+		}
+	}
+	return ratings, nil
+}
+
+// Fills in the ratings of drivers or passengers, as given by group. Ratings
+// are left empty if tripHistory cannot give them, so accounts can still be
+// served.
+func fillRatings(group string, items ...ratedAccount) {
+	if len(items) == 0 {
+		return
+	}
+
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.accountId()
+	}
+
+	ratings, err := fetchRatings(group, ids)
+	if err != nil {
+		log.Println("fillRatings: Error in fetch" + err.Error())
+		return
+	}
+
+	for _, item := range items {
+		item.setRating(ratings[strconv.FormatInt(item.accountId(), 10)])
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const passwordResetLifetime = 30 * time.Minute
+
+// Minimum time between reset tokens, and the maximum number of tokens per
+// hour, for each user
+const passwordResetInterval = time.Minute
+const maxPasswordResetsPerHour = 5
+
+// Returned when a reset token is unknown, expired or already used
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// Sets a new password with a reset token, which is used up along with every
+// other outstanding token of the user. Every session of the user is revoked.
+func (repo *accountRepository) ResetPassword(token string, passwordHash string) error {
+	return repo.transaction(func(tx *sql.Tx) error {
+		var userId int64
+		now := time.Now().Unix()
+		err := tx.QueryRow(`SELECT r.userId
+			FROM password_reset r INNER JOIN user u ON r.userId = u.id
+			WHERE r.tokenHash = ? AND r.consumedAt IS NULL AND r.expiresAt > ? AND u.deletedAt IS NULL
+			FOR UPDATE`,
+			hashOpaqueToken(token), now,
+		).Scan(&userId)
+		if err == sql.ErrNoRows {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.exec(tx, "UPDATE password_reset SET consumedAt = ? WHERE userId = ? AND consumedAt IS NULL", now, userId)
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.exec(tx, "UPDATE credential SET passwordHash = ? WHERE userId = ?", passwordHash, userId)
+		if err != nil {
+			return err
+		}
+
+		// Sign out everyone who may have known the old password
+		_, err = repo.exec(tx, "UPDATE session SET revokedAt = ? WHERE userId = ? AND revokedAt IS NULL", now, userId)
+		if err != nil {
+			return err
+		}
+
+		// The password itself is never recorded
+		return repo.audit(tx, strconv.FormatInt(userId, 10), auditFieldPassword, nil, nil, Identity{UserId: userId})
+	})
+}
+
+// --------------
+
+type RequestPasswordResetInfo struct {
+	// Either the email or the mobile number of the account
+	Email    string `json:"email"`
+	MobileNo string `json:"mobileNo"`
+}
+
+// Sends a reset token to the email or mobile number of an account. The
+// response is the same whether or not there is such an account, so it
+// cannot be used to find out who has signed up.
+func requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var info RequestPasswordResetInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	info.Email = normalizeText(info.Email)
+	info.MobileNo = normalizeIdentifier(info.MobileNo)
+	if (info.Email == "") == (info.MobileNo == "") {
+		writeValidationErrors(w, r, []FieldError{
+			{Field: "email", Message: "or mobileNo is required, but not both"},
+		})
+		return
+	}
+
+	channel, column, destination := ChannelEmail, "email", info.Email
+	if info.MobileNo != "" {
+		channel, column, destination = ChannelMobile, "mobileNo", info.MobileNo
+	}
+
+	resp := RegularResponse{
+		Status:      true,
+		Description: "If an account has this " + column + ", a reset token has been sent to it.",
+	}
+
+	// Throttle resending, without telling whether the account exists
+	now := time.Now()
+	var userId int64
+	var lastCreatedAt sql.NullInt64
+	var recentCount int
+	err := db.QueryRow(`SELECT u.id, MAX(r.createdAt), COUNT(r.id)
+		FROM user u
+		LEFT JOIN password_reset r ON u.id = r.userId AND r.createdAt > ?
+		WHERE u.`+column+` = ? AND u.deletedAt IS NULL
+		GROUP BY u.id`,
+		now.Add(-time.Hour).Unix(), destination,
+	).Scan(&userId, &lastCreatedAt, &recentCount)
+	if err == sql.ErrNoRows {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("requestPasswordReset: Error in query" + err.Error())
+		return
+	}
+
+	if recentCount >= maxPasswordResetsPerHour ||
+		lastCreatedAt.Valid && now.Before(time.Unix(lastCreatedAt.Int64, 0).Add(passwordResetInterval)) {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		writeErrorStatus(w, r, "Could not generate token", http.StatusInternalServerError)
+		log.Println("requestPasswordReset: Error in token" + err.Error())
+		return
+	}
+
+	_, err = db.Exec(`INSERT INTO password_reset
+		(userId, channel, tokenHash, createdAt, expiresAt)
+		VALUES (?, ?, ?, ?, ?)`,
+		userId, channel, tokenHash, now.Unix(), now.Add(passwordResetLifetime).Unix())
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("requestPasswordReset: Error in exec" + err.Error())
+		return
+	}
+
+	err = sender.Send(channel, destination, fmt.Sprintf(
+		"Your SledAway password reset token is %v. It expires in %v minutes. If you did not ask to reset your password, ignore this message.",
+		token, int(passwordResetLifetime/time.Minute)))
+	// A failure is only logged, as answering differently would tell that the
+	// account exists
+	if err != nil {
+		log.Println("requestPasswordReset: Error in send" + err.Error())
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+type ResetPasswordInfo struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Sets a new password with a reset token and signs out every session
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	var info ResetPasswordInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	var v validator
+	v.required("token", info.Token)
+	v.password("password", info.Password)
+	if len(v.errors) > 0 {
+		writeValidationErrors(w, r, v.errors)
+		return
+	}
+
+	passwordHash, err := hashPassword(info.Password)
+	if err != nil {
+		writeErrorStatus(w, r, "Could not hash password", http.StatusInternalServerError)
+		log.Println("resetPassword: Error in hash" + err.Error())
+		return
+	}
+
+	err = accounts.ResetPassword(info.Token, passwordHash)
+	if err == errInvalidResetToken {
+		writeErrorStatus(w, r, "Invalid or expired reset token. Please request a new one.", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("resetPassword: Error in reset" + err.Error())
+		return
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Returned when the account does not exist or is deleted
+var errAccountNotFound = errors.New("account not found")
+
+// Returned when a unique field already holds the value
+type DuplicateError struct {
+	Field string
+}
+
+func (e *DuplicateError) Error() string {
+	return "already in use: " + e.Field
+}
+
+// Converts duplicate key errors from the DB to a DuplicateError
+func checkDuplicate(err error) error {
+	if field, ok := duplicateField(err); ok {
+		return &DuplicateError{Field: field}
+	}
+	return err
+}
+
+// Writes the response for an error from the account repository that a client
+// can act on. Returns whether a response was written, otherwise the error is
+// left to the caller.
+func writeAccountError(w http.ResponseWriter, r *http.Request, id string, err error) bool {
+	var duplicate *DuplicateError
+	if errors.As(err, &duplicate) {
+		writeConflict(w, r, duplicate.Field)
+		return true
+	}
+	if err == errAccountNotFound {
+		writeErrorStatus(w, r, "Id not found: "+id, http.StatusNotFound)
+		return true
+	}
+	return false
+}
+
+// Writes accounts to the DB. Writes to several tables are done in a single
+// transaction, so a failed write leaves nothing behind.
+type accountRepository struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+var accounts *accountRepository
+
+func newAccountRepository(db *sql.DB) *accountRepository {
+	return &accountRepository{
+		db:    db,
+		stmts: map[string]*sql.Stmt{},
+	}
+}
+
+// Gets the prepared statement of a query, preparing it on first use
+func (repo *accountRepository) prepare(query string) (*sql.Stmt, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if stmt, ok := repo.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := repo.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	repo.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Runs a query in the transaction with its prepared statement
+func (repo *accountRepository) exec(tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := repo.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Stmt(stmt).Exec(args...)
+	return res, checkDuplicate(err)
+}
+
+// Runs fn in a transaction, which is committed if fn succeeds
+func (repo *accountRepository) transaction(fn func(tx *sql.Tx) error) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Locks a user that is not deleted and has the role, and gets the current
+// values of the columns. Returns errAccountNotFound if there is none.
+func (repo *accountRepository) lockAccount(tx *sql.Tx, roleTable string, id string, columns []string) ([]sql.NullString, error) {
+	selected := append([]string{"u.id"}, columns...)
+	stmt, err := repo.prepare(`SELECT ` + strings.Join(selected, ", ") + `
+		FROM user u INNER JOIN ` + roleTable + ` t ON u.id = t.userId
+		WHERE u.id = ? AND u.deletedAt IS NULL FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+
+	var userId int64
+	values := make([]sql.NullString, len(columns))
+	dest := []interface{}{&userId}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+
+	err = tx.Stmt(stmt).QueryRow(id).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, errAccountNotFound
+	}
+	return values, err
+}
+
+// Locks a user that is not deleted, in any role. Returns errAccountNotFound
+// if there is none.
+func (repo *accountRepository) lockUser(tx *sql.Tx, id string) error {
+	var exists bool
+	err := tx.QueryRow("SELECT COUNT(*) > 0 FROM user WHERE id = ? AND deletedAt IS NULL FOR UPDATE", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errAccountNotFound
+	}
+	return nil
+}
+
+// Inserts the user and its credential. Returns the id of the user.
+func (repo *accountRepository) insertUser(tx *sql.Tx, firstName, lastName, mobileNo, email, passwordHash string) (int64, error) {
+	res, err := repo.exec(tx, "INSERT INTO `user` (`firstName`, `lastName`, `mobileNo`, `email`) VALUES (?, ?, ?, ?)",
+		firstName, lastName, mobileNo, email)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = repo.exec(tx, "INSERT INTO `credential` (`userId`, `passwordHash`) VALUES (?, ?)", id, passwordHash)
+	return id, err
+}
+
+// Inserts a passenger with its user and credential
+func (repo *accountRepository) insertPassenger(tx *sql.Tx, info CreatePassengerInfo, passwordHash string) (int64, error) {
+	id, err := repo.insertUser(tx, info.FirstName, info.LastName, info.MobileNo, info.Email, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = repo.exec(tx, "INSERT INTO `passenger` (`userId`) VALUES (?)", id)
+	return id, err
+}
+
+// Inserts a driver with its user and credential
+func (repo *accountRepository) insertDriver(tx *sql.Tx, info CreateDriverInfo, passwordHash string) (int64, error) {
+	id, err := repo.insertUser(tx, info.FirstName, info.LastName, info.MobileNo, info.Email, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = repo.exec(tx, "INSERT INTO `driver` (`userId`, `identificationNo`, `carNo`) VALUES (?, ?, ?)",
+		id, info.IdentificationNo, info.CarNo)
+	return id, err
+}
+
+// --------------
+
+func (repo *accountRepository) CreatePassenger(info CreatePassengerInfo, passwordHash string) (int64, error) {
+	var id int64
+	err := repo.transaction(func(tx *sql.Tx) error {
+		var err error
+		id, err = repo.insertPassenger(tx, info, passwordHash)
+		return err
+	})
+	return id, err
+}
+
+func (repo *accountRepository) CreateDriver(info CreateDriverInfo, passwordHash string) (int64, error) {
+	var id int64
+	err := repo.transaction(func(tx *sql.Tx) error {
+		var err error
+		id, err = repo.insertDriver(tx, info, passwordHash)
+		return err
+	})
+	return id, err
+}
+
+func (repo *accountRepository) UpdatePassenger(id string, info UpdatePassengerInfo, actor Identity) error {
+	return repo.UpdateColumns(RolePassenger, id, passengerPatchFields, map[string]string{
+		"firstName": info.FirstName,
+		"lastName":  info.LastName,
+		"mobileNo":  info.MobileNo,
+		"email":     info.Email,
+	}, actor)
+}
+
+func (repo *accountRepository) UpdateDriver(id string, info UpdateDriverInfo, actor Identity) error {
+	return repo.UpdateColumns(RoleDriver, id, driverPatchFields, map[string]string{
+		"firstName": info.FirstName,
+		"lastName":  info.LastName,
+		"mobileNo":  info.MobileNo,
+		"email":     info.Email,
+		"carNo":     info.CarNo,
+	}, actor)
+}
+
+// Updates only the given columns of a user with the role, recording the
+// changed ones. The columns must be of the role's patch fields.
+func (repo *accountRepository) UpdateColumns(role string, id string, columns []string, values map[string]string, actor Identity) error {
+	var userSets, roleSets []string
+	var userArgs, roleArgs []interface{}
+	for _, column := range columns {
+		// carNo is the only field of the role table
+		if column == "carNo" {
+			roleSets = append(roleSets, column+" = ?")
+			roleArgs = append(roleArgs, values[column])
+		} else {
+			userSets = append(userSets, column+" = ?")
+			userArgs = append(userArgs, values[column])
+		}
+	}
+
+	return repo.transaction(func(tx *sql.Tx) error {
+		current, err := repo.lockAccount(tx, role, id, columns)
+		if err != nil {
+			return err
+		}
+
+		if len(userSets) > 0 {
+			_, err = repo.exec(tx, "UPDATE user SET "+strings.Join(userSets, ", ")+" WHERE id = ?",
+				append(userArgs, id)...)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(roleSets) > 0 {
+			_, err = repo.exec(tx, "UPDATE "+role+" SET "+strings.Join(roleSets, ", ")+" WHERE userId = ?",
+				append(roleArgs, id)...)
+			if err != nil {
+				return err
+			}
+		}
+
+		for i, column := range columns {
+			err = repo.auditChange(tx, id, column, current[i], values[column], actor)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Makes an existing user a passenger
+func (repo *accountRepository) AddPassengerRole(id string, actor Identity) error {
+	return repo.transaction(func(tx *sql.Tx) error {
+		_, err := repo.exec(tx, "INSERT INTO `passenger` (`userId`) VALUES (?)", id)
+		if err != nil {
+			return err
+		}
+
+		role := RolePassenger
+		return repo.audit(tx, id, auditFieldRole, nil, &role, actor)
+	})
+}
+
+// Makes an existing user a driver
+func (repo *accountRepository) AddDriverRole(id string, info AddDriverRoleInfo, actor Identity) error {
+	return repo.transaction(func(tx *sql.Tx) error {
+		_, err := repo.exec(tx, "INSERT INTO `driver` (`userId`, `identificationNo`, `carNo`) VALUES (?, ?, ?)",
+			id, info.IdentificationNo, info.CarNo)
+		if err != nil {
+			return err
+		}
+
+		role := RoleDriver
+		err = repo.audit(tx, id, auditFieldRole, nil, &role, actor)
+		if err != nil {
+			return err
+		}
+		err = repo.audit(tx, id, "identificationNo", nil, &info.IdentificationNo, actor)
+		if err != nil {
+			return err
+		}
+		return repo.audit(tx, id, "carNo", nil, &info.CarNo, actor)
+	})
+}
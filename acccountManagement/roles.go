@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Ensures the caller is the user with the id given in the request path, in
+// any role
+func ensureSelfPath(w http.ResponseWriter, r *http.Request, reqId string) bool {
+	if reqId != strconv.FormatInt(callerIdentity(r).UserId, 10) {
+		writeErrorStatus(w, r, "You may not act on behalf of another user", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Driver details of a user that is a driver
+type UserDriverInfo struct {
+	IdentificationNo string `json:"identificationNo"`
+	CarNo            string `json:"carNo"`
+	Status           string `json:"status"`
+}
+
+type GetUserResponse struct {
+	Id             int64           `json:"id"`
+	FirstName      string          `json:"firstName"`
+	LastName       string          `json:"lastName"`
+	MobileNo       string          `json:"mobileNo"`
+	Email          string          `json:"email"`
+	EmailVerified  bool            `json:"emailVerified"`
+	MobileVerified bool            `json:"mobileVerified"`
+	Roles          []string        `json:"roles"`
+	Suspension     *SuspensionInfo `json:"suspension"`
+	Driver         *UserDriverInfo `json:"driver,omitempty"`
+}
+
+// Gets a user that is not deleted, with all of their roles. Returns
+// sql.ErrNoRows if not found.
+func fetchUser(id string) (GetUserResponse, error) {
+	resp := GetUserResponse{
+		Roles: []string{},
+	}
+
+	var isPassenger bool
+	var identificationNo, carNo, status, staffRole sql.NullString
+	var suspension nullSuspension
+	err := db.QueryRow(`SELECT
+		u.id, u.firstName, u.lastName, u.mobileNo, u.email, `+verifiedColumns+`,
+		p.userId IS NOT NULL, d.identificationNo, d.carNo, d.status, s.role,
+		`+suspensionColumns+`
+		FROM user u
+		LEFT JOIN passenger p ON u.id = p.userId
+		LEFT JOIN driver d ON u.id = d.userId
+		LEFT JOIN staff s ON u.id = s.userId`+suspensionJoin+`
+		WHERE u.id = ? AND u.deletedAt IS NULL`, id,
+	).Scan(
+		&resp.Id, &resp.FirstName, &resp.LastName,
+		&resp.MobileNo, &resp.Email,
+		&resp.EmailVerified, &resp.MobileVerified,
+		&isPassenger, &identificationNo, &carNo, &status, &staffRole,
+		&suspension.Reason, &suspension.ExpiresAt, &suspension.SuspendedAt,
+	)
+	if err != nil {
+		return resp, err
+	}
+
+	if isPassenger {
+		resp.Roles = append(resp.Roles, RolePassenger)
+	}
+	if identificationNo.Valid {
+		resp.Roles = append(resp.Roles, RoleDriver)
+		resp.Driver = &UserDriverInfo{
+			IdentificationNo: identificationNo.String,
+			CarNo:            carNo.String,
+			Status:           status.String,
+		}
+	}
+	resp.Suspension = suspension.info()
+	if staffRole.Valid {
+		resp.Roles = append(resp.Roles, staffRole.String)
+	}
+
+	return resp, nil
+}
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	if !ensureSelfPathOr(w, r, reqId, PermReadAccounts) {
+		return
+	}
+
+	resp, err := fetchUser(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("getUser: Error in query" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Checks that the signed in user exists and does not have the role yet.
+// Returns whether to continue.
+func ensureCanAddRole(w http.ResponseWriter, r *http.Request, reqId string, role string) bool {
+	if !ensureSelfPath(w, r, reqId) {
+		return false
+	}
+
+	user, err := fetchUser(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("ensureCanAddRole: Error in query" + err.Error())
+		return false
+	}
+
+	if containsString(user.Roles, role) {
+		writeErrorStatus(w, r, "User is already a "+role, http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// Makes an existing user a passenger
+func addPassengerRole(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	if !ensureCanAddRole(w, r, reqId, RolePassenger) {
+		return
+	}
+
+	err := accounts.AddPassengerRole(reqId, callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("addPassengerRole: Error in add" + err.Error())
+		return
+	}
+
+	getUser(w, r)
+}
+
+type AddDriverRoleInfo struct {
+	IdentificationNo string `json:"identificationNo"`
+	CarNo            string `json:"carNo"`
+}
+
+func (info *AddDriverRoleInfo) validate() []FieldError {
+	info.IdentificationNo = normalizeIdentifier(info.IdentificationNo)
+	info.CarNo = normalizeIdentifier(info.CarNo)
+
+	var v validator
+	v.identificationNo("identificationNo", info.IdentificationNo)
+	v.carNo("carNo", info.CarNo)
+	return v.errors
+}
+
+// Makes an existing user a driver
+func addDriverRole(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	var info AddDriverRoleInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	if !ensureCanAddRole(w, r, reqId, RoleDriver) {
+		return
+	}
+
+	err := accounts.AddDriverRole(reqId, info, callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("addDriverRole: Error in add" + err.Error())
+		return
+	}
+
+	getUser(w, r)
+}
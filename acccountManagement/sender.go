@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Channels a message can be sent through
+const (
+	ChannelEmail  = "email"
+	ChannelMobile = "mobile"
+)
+
+// Delivers messages to users, e.g. by email or SMS
+type Sender interface {
+	Send(channel string, destination string, message string) error
+}
+
+var sender Sender
+
+// Sets up the sender. Until a real email/SMS provider is configured, messages
+// are written to the file in SLEDAWAY_OUTBOX_FILE, or to the log if not set.
+func loadSender() {
+	path := os.Getenv("SLEDAWAY_OUTBOX_FILE")
+	if path == "" {
+		log.Println("SLEDAWAY_OUTBOX_FILE is not set, messages to users are logged")
+		sender = logSender{}
+		return
+	}
+	sender = &fileSender{path: path}
+}
+
+// Writes messages to the log
+type logSender struct{}
+
+func (logSender) Send(channel string, destination string, message string) error {
+	log.Printf("Message to %v %v: %v", channel, destination, message)
+	return nil
+}
+
+// A message written by fileSender
+type OutboxMessage struct {
+	Time        int64  `json:"time"`
+	Channel     string `json:"channel"`
+	Destination string `json:"destination"`
+	Message     string `json:"message"`
+}
+
+// Appends messages to a file, one JSON object per line, so they can be read
+// back in tests
+type fileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *fileSender) Send(channel string, destination string, message string) error {
+	data, err := json.Marshal(OutboxMessage{
+		Time:        time.Now().Unix(),
+		Channel:     channel,
+		Destination: destination,
+		Message:     message,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
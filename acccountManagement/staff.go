@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Checks whether the user holds the staff role
+func userHasStaffRole(userId int64, role string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM staff WHERE userId = ? AND role = ?", userId, role).Scan(&count)
+	return count > 0, err
+}
+
+// Sets the staff role of the user, or removes it if role is empty. Sessions
+// signed in with the previous staff role are revoked.
+func (repo *accountRepository) SetStaffRole(id string, role string, actor Identity) error {
+	return repo.transaction(func(tx *sql.Tx) error {
+		err := repo.lockUser(tx, id)
+		if err != nil {
+			return err
+		}
+
+		var previous sql.NullString
+		err = tx.QueryRow("SELECT role FROM staff WHERE userId = ?", id).Scan(&previous)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if previous.String == role {
+			return nil
+		}
+
+		if role == "" {
+			_, err = repo.exec(tx, "DELETE FROM staff WHERE userId = ?", id)
+		} else {
+			_, err = repo.exec(tx, `INSERT INTO staff
+				(userId, role, grantedAt)
+				VALUES (?, ?, ?)
+				ON DUPLICATE KEY UPDATE role = VALUES(role), grantedAt = VALUES(grantedAt)`,
+				id, role, time.Now().Unix())
+		}
+		if err != nil {
+			return err
+		}
+
+		if previous.Valid {
+			_, err = repo.exec(tx, "UPDATE session SET revokedAt = ? WHERE userId = ? AND role = ? AND revokedAt IS NULL",
+				time.Now().Unix(), id, previous.String)
+			if err != nil {
+				return err
+			}
+		}
+
+		var newRole *string
+		if role != "" {
+			newRole = &role
+		}
+		return repo.audit(tx, id, auditFieldStaffRole, nullableString(previous), newRole, actor)
+	})
+}
+
+// Gets a pointer to the value, or nil if it is NULL
+func nullableString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+// --------------
+
+type StaffRoleInfo struct {
+	Role string `json:"role"`
+}
+
+func grantStaffRole(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	var info StaffRoleInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	var v validator
+	if v.required("role", info.Role) && !containsString(staffRoles, info.Role) {
+		v.fail("role", "must be one of "+strings.Join(staffRoles, ", "))
+	}
+	if len(v.errors) > 0 {
+		writeValidationErrors(w, r, v.errors)
+		return
+	}
+
+	err := accounts.SetStaffRole(reqId, info.Role, callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("grantStaffRole: Error in exec" + err.Error())
+		return
+	}
+
+	getUser(w, r)
+}
+
+func revokeStaffRole(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	// Keeps at least one admin able to grant roles
+	if reqId == strconv.FormatInt(callerIdentity(r).UserId, 10) {
+		writeErrorStatus(w, r, "You may not remove your own staff role", http.StatusConflict)
+		return
+	}
+
+	err := accounts.SetStaffRole(reqId, "", callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("revokeStaffRole: Error in exec" + err.Error())
+		return
+	}
+
+	getUser(w, r)
+}
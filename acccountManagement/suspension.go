@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Maximum length of the reason for a suspension
+const maxSuspensionReasonLength = 255
+
+// Joins the suspension in effect for the user u, if any. At most one is in
+// effect, as suspending lifts the previous suspension.
+const suspensionJoin = ` LEFT JOIN suspension su ON u.id = su.userId
+	AND su.liftedAt IS NULL AND (su.expiresAt IS NULL OR su.expiresAt > UNIX_TIMESTAMP())`
+
+// Columns of the joined suspension, scanned with a nullSuspension
+const suspensionColumns = "su.reason, su.expiresAt, su.createdAt"
+
+// Returned when lifting the suspension of a user that is not suspended
+var errNotSuspended = errors.New("user is not suspended")
+
+// A suspension in effect. Suspended users may still sign in and manage their
+// account, but may not request or take trips.
+type SuspensionInfo struct {
+	Reason string `json:"reason"`
+	// Unix time the suspension ends at, or null if it does not end
+	ExpiresAt   *int64 `json:"expiresAt"`
+	SuspendedAt int64  `json:"suspendedAt"`
+}
+
+// Scans the suspension columns, which are NULL if the user is not suspended
+type nullSuspension struct {
+	Reason      sql.NullString
+	ExpiresAt   sql.NullInt64
+	SuspendedAt sql.NullInt64
+}
+
+// Gets the suspension in effect, or nil if there is none
+func (s nullSuspension) info() *SuspensionInfo {
+	if !s.Reason.Valid {
+		return nil
+	}
+
+	info := &SuspensionInfo{
+		Reason:      s.Reason.String,
+		SuspendedAt: s.SuspendedAt.Int64,
+	}
+	if s.ExpiresAt.Valid {
+		info.ExpiresAt = &s.ExpiresAt.Int64
+	}
+	return info
+}
+
+// Suspends the user, replacing the suspension in effect if any. A nil
+// expiresAt suspends the user until the suspension is lifted.
+func (repo *accountRepository) Suspend(id string, reason string, expiresAt *int64, actor Identity) error {
+	return repo.transaction(func(tx *sql.Tx) error {
+		previous, err := repo.liftSuspension(tx, id, actor)
+		if err != nil && err != errNotSuspended {
+			return err
+		}
+
+		_, err = repo.exec(tx, `INSERT INTO suspension
+			(userId, reason, expiresAt, createdAt, createdBy)
+			VALUES (?, ?, ?, ?, ?)`,
+			id, reason, expiresAt, time.Now().Unix(), actor.UserId)
+		if err != nil {
+			return err
+		}
+
+		return repo.audit(tx, id, auditFieldSuspension, nullableString(previous), &reason, actor)
+	})
+}
+
+// Lifts the suspension in effect for the user. Returns errNotSuspended if
+// there is none.
+func (repo *accountRepository) Unsuspend(id string, actor Identity) error {
+	return repo.transaction(func(tx *sql.Tx) error {
+		previous, err := repo.liftSuspension(tx, id, actor)
+		if err != nil {
+			return err
+		}
+
+		return repo.audit(tx, id, auditFieldSuspension, nullableString(previous), nil, actor)
+	})
+}
+
+// Locks the user and lifts every suspension not lifted yet. Gets the reason of
+// the one in effect, or returns errNotSuspended if none is.
+func (repo *accountRepository) liftSuspension(tx *sql.Tx, id string, actor Identity) (sql.NullString, error) {
+	var reason sql.NullString
+	err := repo.lockUser(tx, id)
+	if err != nil {
+		return reason, err
+	}
+
+	now := time.Now().Unix()
+	err = tx.QueryRow(`SELECT reason FROM suspension
+		WHERE userId = ? AND liftedAt IS NULL AND (expiresAt IS NULL OR expiresAt > ?)`,
+		id, now).Scan(&reason)
+	if err != nil && err != sql.ErrNoRows {
+		return reason, err
+	}
+
+	_, err = repo.exec(tx, "UPDATE suspension SET liftedAt = ?, liftedBy = ? WHERE userId = ? AND liftedAt IS NULL",
+		now, actor.UserId, id)
+	if err != nil {
+		return reason, err
+	}
+
+	if !reason.Valid {
+		return reason, errNotSuspended
+	}
+	return reason, nil
+}
+
+// --------------
+
+type SuspendInfo struct {
+	Reason string `json:"reason"`
+	// Optional unix time to end the suspension at
+	ExpiresAt *int64 `json:"expiresAt"`
+}
+
+func (info *SuspendInfo) validate() []FieldError {
+	info.Reason = normalizeText(info.Reason)
+
+	var v validator
+	if v.required("reason", info.Reason) && len(info.Reason) > maxSuspensionReasonLength {
+		v.fail("reason", "is too long")
+	}
+	if info.ExpiresAt != nil && *info.ExpiresAt <= time.Now().Unix() {
+		v.fail("expiresAt", "must be in the future")
+	}
+	return v.errors
+}
+
+func suspendUser(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	var info SuspendInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	err := accounts.Suspend(reqId, info.Reason, info.ExpiresAt, callerIdentity(r))
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("suspendUser: Error in exec" + err.Error())
+		return
+	}
+
+	getUser(w, r)
+}
+
+func unsuspendUser(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	err := accounts.Unsuspend(reqId, callerIdentity(r))
+	if err == errNotSuspended {
+		writeErrorStatus(w, r, "User is not suspended: "+reqId, http.StatusConflict)
+		return
+	}
+	if writeAccountError(w, r, reqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("unsuspendUser: Error in exec" + err.Error())
+		return
+	}
+
+	getUser(w, r)
+}
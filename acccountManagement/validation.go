@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// Maximum length of the text columns in the user and driver tables
+const maxFieldLength = 127
+
+// E.164 phone number, e.g. +6591234567
+var mobileNoPattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Singapore vehicle plate, e.g. SBA1234A
+var carNoPattern = regexp.MustCompile(`^[A-Z]{1,3}[0-9]{1,4}[A-Z]$`)
+
+// Singapore postal code, e.g. 018956
+var postalCodePattern = regexp.MustCompile(`^[0-9]{6}$`)
+
+// Singapore NRIC/FIN, e.g. S1234567D
+var identificationNoPattern = regexp.MustCompile(`^[STFGM][0-9]{7}[A-Z]$`)
+
+// A validation failure of a single field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// A JSON response listing the invalid fields of a request
+type ValidationResponse struct {
+	Status      bool         `json:"status"`
+	Description string       `json:"description"`
+	Errors      []FieldError `json:"errors"`
+}
+
+// Writes a validation error response
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errors []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(ValidationResponse{
+		Status:      false,
+		Description: "One or more fields are invalid",
+		Errors:      errors,
+	})
+}
+
+// Collects field errors
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) fail(field string, message string) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: message})
+}
+
+// Checks that the field is not empty and fits in the DB. Returns whether
+// further checks should be done.
+func (v *validator) required(field string, value string) bool {
+	if value == "" {
+		v.fail(field, "is required")
+		return false
+	}
+	if len(value) > maxFieldLength {
+		v.fail(field, "is too long")
+		return false
+	}
+	return true
+}
+
+func (v *validator) name(field string, value string) {
+	v.required(field, value)
+}
+
+func (v *validator) email(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+
+	// Reject display names, e.g. "Name <a@b.com>"
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		v.fail(field, "is not a valid email address")
+		return
+	}
+
+	domain := value[strings.LastIndex(value, "@")+1:]
+	if !strings.Contains(domain, ".") {
+		v.fail(field, "is not a valid email address")
+	}
+}
+
+func (v *validator) mobileNo(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	if !mobileNoPattern.MatchString(value) {
+		v.fail(field, "must be in international format, e.g. +6591234567")
+	}
+}
+
+func (v *validator) identificationNo(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	if !identificationNoPattern.MatchString(value) || !validNricChecksum(value) {
+		v.fail(field, "is not a valid NRIC/FIN")
+	}
+}
+
+func (v *validator) carNo(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	if !carNoPattern.MatchString(value) {
+		v.fail(field, "is not a valid vehicle plate, e.g. SBA1234A")
+	}
+}
+
+func (v *validator) postalCode(field string, value string) {
+	if !v.required(field, value) {
+		return
+	}
+	if !postalCodePattern.MatchString(value) {
+		v.fail(field, "is not a valid postal code, e.g. 018956")
+	}
+}
+
+// Checks that the optional field fits in the DB
+func (v *validator) optional(field string, value string, maxLength int) {
+	if len(value) > maxLength {
+		v.fail(field, "is too long")
+	}
+}
+
+func (v *validator) password(field string, value string) {
+	if err := checkPassword(value); err != nil {
+		v.fail(field, err.Error())
+	}
+}
+
+// Checks the check letter of a NRIC/FIN that matches identificationNoPattern
+func validNricChecksum(id string) bool {
+	weights := []int{2, 7, 6, 5, 4, 3, 2}
+	sum := 0
+	for i, weight := range weights {
+		sum += int(id[i+1]-'0') * weight
+	}
+
+	prefix := id[0]
+	switch prefix {
+	case 'T', 'G':
+		sum += 4
+	case 'M':
+		sum += 3
+	}
+
+	var table string
+	index := sum % 11
+	switch prefix {
+	case 'S', 'T':
+		table = "JZIHGFEDCBA"
+	case 'F', 'G':
+		table = "XWUTRQPNMLK"
+	case 'M':
+		table = "KLJNPQRTUWX"
+		index = 10 - index
+	}
+
+	return id[8] == table[index]
+}
+
+// Trims spaces around text
+func normalizeText(value string) string {
+	return strings.TrimSpace(value)
+}
+
+// Removes spaces in identifiers and uppercases them
+func normalizeIdentifier(value string) string {
+	return strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+}
+
+// --------------
+
+func (info *CreatePassengerInfo) validate() []FieldError {
+	info.FirstName = normalizeText(info.FirstName)
+	info.LastName = normalizeText(info.LastName)
+	info.MobileNo = normalizeIdentifier(info.MobileNo)
+	info.Email = normalizeText(info.Email)
+
+	var v validator
+	v.name("firstName", info.FirstName)
+	v.name("lastName", info.LastName)
+	v.mobileNo("mobileNo", info.MobileNo)
+	v.email("email", info.Email)
+	v.password("password", info.Password)
+	return v.errors
+}
+
+func (info *UpdatePassengerInfo) validate() []FieldError {
+	info.FirstName = normalizeText(info.FirstName)
+	info.LastName = normalizeText(info.LastName)
+	info.MobileNo = normalizeIdentifier(info.MobileNo)
+	info.Email = normalizeText(info.Email)
+
+	var v validator
+	v.name("firstName", info.FirstName)
+	v.name("lastName", info.LastName)
+	v.mobileNo("mobileNo", info.MobileNo)
+	v.email("email", info.Email)
+	return v.errors
+}
+
+func (info *CreateDriverInfo) validate() []FieldError {
+	info.FirstName = normalizeText(info.FirstName)
+	info.LastName = normalizeText(info.LastName)
+	info.MobileNo = normalizeIdentifier(info.MobileNo)
+	info.Email = normalizeText(info.Email)
+	info.IdentificationNo = normalizeIdentifier(info.IdentificationNo)
+	info.CarNo = normalizeIdentifier(info.CarNo)
+
+	var v validator
+	v.name("firstName", info.FirstName)
+	v.name("lastName", info.LastName)
+	v.mobileNo("mobileNo", info.MobileNo)
+	v.email("email", info.Email)
+	v.password("password", info.Password)
+	v.identificationNo("identificationNo", info.IdentificationNo)
+	v.carNo("carNo", info.CarNo)
+	return v.errors
+}
+
+func (info *UpdateDriverInfo) validate() []FieldError {
+	info.FirstName = normalizeText(info.FirstName)
+	info.LastName = normalizeText(info.LastName)
+	info.MobileNo = normalizeIdentifier(info.MobileNo)
+	info.Email = normalizeText(info.Email)
+	info.CarNo = normalizeIdentifier(info.CarNo)
+
+	var v validator
+	v.name("firstName", info.FirstName)
+	v.name("lastName", info.LastName)
+	v.mobileNo("mobileNo", info.MobileNo)
+	v.email("email", info.Email)
+	v.carNo("carNo", info.CarNo)
+	return v.errors
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Classes of vehicles a driver may register
+var vehicleClasses = []string{"standard", "premium", "xl"}
+
+const maxVehicleSeats = 12
+
+type VehicleInfo struct {
+	Id           int64  `json:"id"`
+	Plate        string `json:"plate"`
+	Make         string `json:"make"`
+	Model        string `json:"model"`
+	Colour       string `json:"colour"`
+	Seats        int    `json:"seats"`
+	VehicleClass string `json:"vehicleClass"`
+	Active       bool   `json:"active"`
+}
+
+func (info *VehicleInfo) validate() []FieldError {
+	info.Plate = normalizeIdentifier(info.Plate)
+	info.Make = normalizeText(info.Make)
+	info.Model = normalizeText(info.Model)
+	info.Colour = normalizeText(info.Colour)
+
+	var v validator
+	v.carNo("plate", info.Plate)
+	v.required("make", info.Make)
+	v.required("model", info.Model)
+	v.required("colour", info.Colour)
+	if info.Seats < 1 || info.Seats > maxVehicleSeats {
+		v.fail("seats", "must be from 1 to "+strconv.Itoa(maxVehicleSeats))
+	}
+	if !containsString(vehicleClasses, info.VehicleClass) {
+		v.fail("vehicleClass", "must be one of standard, premium, xl")
+	}
+	return v.errors
+}
+
+const vehicleColumns = "id, plate, make, model, colour, seats, vehicleClass, active"
+
+func scanVehicle(row interface{ Scan(...interface{}) error }, info *VehicleInfo) error {
+	return row.Scan(
+		&info.Id, &info.Plate, &info.Make, &info.Model,
+		&info.Colour, &info.Seats, &info.VehicleClass, &info.Active,
+	)
+}
+
+// Gets the active vehicle of a driver. Returns sql.ErrNoRows if there is none.
+func fetchActiveVehicle(driverId string) (VehicleInfo, error) {
+	var info VehicleInfo
+	err := scanVehicle(db.QueryRow("SELECT "+vehicleColumns+" FROM vehicle WHERE driverId = ? AND active = 1", driverId), &info)
+	return info, err
+}
+
+// Makes the vehicle the only active one of the driver, and the driver's
+// carNo. Returns sql.ErrNoRows if the vehicle is not the driver's.
+func activateVehicle(driverId string, vehicleId string, actor Identity) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var plate string
+	err = tx.QueryRow("SELECT plate FROM vehicle WHERE id = ? AND driverId = ? FOR UPDATE", vehicleId, driverId).Scan(&plate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE vehicle SET active = (id = ?) WHERE driverId = ?", vehicleId, driverId)
+	if err != nil {
+		return err
+	}
+
+	var carNo sql.NullString
+	err = tx.QueryRow("SELECT carNo FROM driver WHERE userId = ? FOR UPDATE", driverId).Scan(&carNo)
+	if err != nil {
+		return err
+	}
+
+	// carNo is kept for clients that do not know about vehicles
+	_, err = tx.Exec("UPDATE driver SET carNo = ? WHERE userId = ?", plate, driverId)
+	if err != nil {
+		return err
+	}
+
+	err = accounts.auditChange(tx, driverId, "carNo", carNo, plate, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Checks that the signed in driver exists. Returns whether to continue.
+func ensureSelfDriver(w http.ResponseWriter, r *http.Request, driverId string) bool {
+	if !ensureCallerPath(w, r, RoleDriver, driverId) {
+		return false
+	}
+
+	_, err := fetchDriver(driverId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+driverId, http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("ensureSelfDriver: Error in query" + err.Error())
+		return false
+	}
+	return true
+}
+
+// --------------
+
+type ListVehiclesResponse struct {
+	Vehicles []VehicleInfo `json:"vehicles"`
+}
+
+func listVehicles(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+
+	if !ensureSelfPathOr(w, r, driverId, PermReadAccounts) {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+vehicleColumns+" FROM vehicle WHERE driverId = ? ORDER BY id", driverId)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("listVehicles: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := ListVehiclesResponse{
+		Vehicles: []VehicleInfo{},
+	}
+	for rows.Next() {
+		var info VehicleInfo
+		if err = scanVehicle(rows, &info); err != nil {
+			writeError(w, r, "DB err 2")
+			log.Println("listVehicles: Error in scan" + err.Error())
+			return
+		}
+		resp.Vehicles = append(resp.Vehicles, info)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getActiveVehicle(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+
+	if !ensureSelfPathOr(w, r, driverId, PermReadAccounts) {
+		return
+	}
+
+	info, err := fetchActiveVehicle(driverId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Driver has no active vehicle: "+driverId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("getActiveVehicle: Error in query" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// Registers a vehicle. The first vehicle of a driver becomes active.
+func createVehicle(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+
+	var info VehicleInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	if !ensureSelfDriver(w, r, driverId) {
+		return
+	}
+
+	res, err := db.Exec(`INSERT INTO vehicle
+		(driverId, plate, make, model, colour, seats, vehicleClass)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		driverId, info.Plate, info.Make, info.Model,
+		info.Colour, info.Seats, info.VehicleClass,
+	)
+	if field, ok := duplicateField(err); ok {
+		writeConflict(w, r, field)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("createVehicle: Error in exec" + err.Error())
+		return
+	}
+
+	info.Id, err = res.LastInsertId()
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("createVehicle: Error in last id" + err.Error())
+		return
+	}
+
+	_, err = fetchActiveVehicle(driverId)
+	if err == sql.ErrNoRows {
+		err = activateVehicle(driverId, strconv.FormatInt(info.Id, 10), callerIdentity(r))
+		info.Active = err == nil
+	}
+	if err != nil {
+		writeError(w, r, "DB err 4")
+		log.Println("createVehicle: Error in activate" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+func updateVehicle(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+	vehicleId := mux.Vars(r)["vehicleId"]
+
+	var info VehicleInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if fieldErrors := info.validate(); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	if !ensureSelfDriver(w, r, driverId) {
+		return
+	}
+
+	_, err := db.Exec(`UPDATE vehicle
+		SET plate = ?, make = ?, model = ?, colour = ?, seats = ?, vehicleClass = ?
+		WHERE id = ? AND driverId = ?`,
+		info.Plate, info.Make, info.Model, info.Colour,
+		info.Seats, info.VehicleClass,
+		vehicleId, driverId,
+	)
+	if field, ok := duplicateField(err); ok {
+		writeConflict(w, r, field)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("updateVehicle: Error in exec" + err.Error())
+		return
+	}
+
+	err = scanVehicle(db.QueryRow("SELECT "+vehicleColumns+" FROM vehicle WHERE id = ? AND driverId = ?", vehicleId, driverId), &info)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Vehicle not found: "+vehicleId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("updateVehicle: Error in query" + err.Error())
+		return
+	}
+
+	// Keep carNo in sync with a changed plate
+	if info.Active {
+		err = activateVehicle(driverId, vehicleId, callerIdentity(r))
+		if err != nil {
+			writeError(w, r, "DB err 4")
+			log.Println("updateVehicle: Error in activate" + err.Error())
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+func setActiveVehicle(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+	vehicleId := mux.Vars(r)["vehicleId"]
+
+	if !ensureSelfDriver(w, r, driverId) {
+		return
+	}
+
+	err := activateVehicle(driverId, vehicleId, callerIdentity(r))
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Vehicle not found: "+vehicleId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("setActiveVehicle: Error in activate" + err.Error())
+		return
+	}
+
+	getActiveVehicle(w, r)
+}
+
+// Removes a vehicle. The active vehicle cannot be removed, another one must be
+// made active first.
+func deleteVehicle(w http.ResponseWriter, r *http.Request) {
+	driverId := mux.Vars(r)["id"]
+	vehicleId := mux.Vars(r)["vehicleId"]
+
+	if !ensureSelfDriver(w, r, driverId) {
+		return
+	}
+
+	var active bool
+	err := db.QueryRow("SELECT active FROM vehicle WHERE id = ? AND driverId = ?", vehicleId, driverId).Scan(&active)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Vehicle not found: "+vehicleId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("deleteVehicle: Error in query" + err.Error())
+		return
+	}
+	if active {
+		writeErrorStatus(w, r, "The active vehicle cannot be removed", http.StatusConflict)
+		return
+	}
+
+	_, err = db.Exec("DELETE FROM vehicle WHERE id = ? AND driverId = ? AND active = 0", vehicleId, driverId)
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("deleteVehicle: Error in exec" + err.Error())
+		return
+	}
+}
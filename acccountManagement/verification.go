@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const verificationCodeLifetime = 10 * time.Minute
+
+// Selects whether the email and mobile number of user u are verified. They
+// are verified only if unchanged since.
+const verifiedColumns = "COALESCE(u.email = u.verifiedEmail, FALSE), COALESCE(u.mobileNo = u.verifiedMobileNo, FALSE)"
+
+// Wrong codes allowed before a new code must be requested
+const maxVerificationAttempts = 5
+
+// Minimum time between codes, and the maximum number of codes per hour, for
+// each channel of a user
+const verificationResendInterval = time.Minute
+const maxVerificationCodesPerHour = 5
+
+// Generates a random numeric one-time code
+func generateCode(digits int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+// Hashes a one-time code for storing. Keyed, as the codes are short.
+func hashCode(code string) string {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Gets the destination of the user's contact details for the channel
+func channelDestination(user GetUserResponse, channel string) string {
+	if channel == ChannelEmail {
+		return user.Email
+	}
+	return user.MobileNo
+}
+
+func channelVerified(user GetUserResponse, channel string) bool {
+	if channel == ChannelEmail {
+		return user.EmailVerified
+	}
+	return user.MobileVerified
+}
+
+// Checks that the channel of a request is known
+func checkChannel(w http.ResponseWriter, r *http.Request, channel string) bool {
+	if channel != ChannelEmail && channel != ChannelMobile {
+		writeValidationErrors(w, r, []FieldError{
+			{Field: "channel", Message: "must be email or mobile"},
+		})
+		return false
+	}
+	return true
+}
+
+// Gets the signed in user of the request path. Returns whether to continue.
+func ensureSelfUser(w http.ResponseWriter, r *http.Request, reqId string) (GetUserResponse, bool) {
+	if !ensureSelfPath(w, r, reqId) {
+		return GetUserResponse{}, false
+	}
+
+	user, err := fetchUser(reqId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Id not found: "+reqId, http.StatusNotFound)
+		return user, false
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("ensureSelfUser: Error in query" + err.Error())
+		return user, false
+	}
+	return user, true
+}
+
+// --------------
+
+type RequestVerificationInfo struct {
+	Channel string `json:"channel"`
+}
+
+type RequestVerificationResponse struct {
+	Channel     string `json:"channel"`
+	Destination string `json:"destination"`
+	ExpiresAt   int64  `json:"expiresAt"`
+}
+
+// Sends a one-time code to the user's email or mobile number
+func requestVerification(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	var info RequestVerificationInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if !checkChannel(w, r, info.Channel) {
+		return
+	}
+
+	user, ok := ensureSelfUser(w, r, reqId)
+	if !ok {
+		return
+	}
+
+	destination := channelDestination(user, info.Channel)
+	if channelVerified(user, info.Channel) {
+		writeErrorStatus(w, r, "Already verified: "+destination, http.StatusConflict)
+		return
+	}
+
+	// Throttle resending
+	now := time.Now()
+	var lastCreatedAt sql.NullInt64
+	var recentCount int
+	err := db.QueryRow(`SELECT MAX(createdAt), COUNT(*)
+		FROM verification_code
+		WHERE userId = ? AND channel = ? AND createdAt > ?`,
+		reqId, info.Channel, now.Add(-time.Hour).Unix(),
+	).Scan(&lastCreatedAt, &recentCount)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("requestVerification: Error in query" + err.Error())
+		return
+	}
+
+	if recentCount >= maxVerificationCodesPerHour {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Hour/time.Second)))
+		writeErrorStatus(w, r, "Too many codes requested. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+	if lastCreatedAt.Valid {
+		wait := time.Unix(lastCreatedAt.Int64, 0).Add(verificationResendInterval).Sub(now)
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+			writeErrorStatus(w, r, "A code was just sent. Please wait before requesting another.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	code, err := generateCode(6)
+	if err != nil {
+		writeErrorStatus(w, r, "Could not generate code", http.StatusInternalServerError)
+		log.Println("requestVerification: Error in code" + err.Error())
+		return
+	}
+
+	// Only the latest code is valid
+	_, err = db.Exec(`UPDATE verification_code SET consumedAt = ?
+		WHERE userId = ? AND channel = ? AND consumedAt IS NULL`,
+		now.Unix(), reqId, info.Channel)
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("requestVerification: Error in exec" + err.Error())
+		return
+	}
+
+	expiresAt := now.Add(verificationCodeLifetime).Unix()
+	_, err = db.Exec(`INSERT INTO verification_code
+		(userId, channel, destination, codeHash, createdAt, expiresAt)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		reqId, info.Channel, destination, hashCode(code), now.Unix(), expiresAt)
+	if err != nil {
+		writeError(w, r, "DB err 4")
+		log.Println("requestVerification: Error in exec" + err.Error())
+		return
+	}
+
+	err = sender.Send(info.Channel, destination, fmt.Sprintf(
+		"Your SledAway verification code is %v. It expires in %v minutes.",
+		code, int(verificationCodeLifetime/time.Minute)))
+	if err != nil {
+		writeErrorStatus(w, r, "Could not send code", http.StatusBadGateway)
+		log.Println("requestVerification: Error in send" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(RequestVerificationResponse{
+		Channel:     info.Channel,
+		Destination: destination,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+type ConfirmVerificationInfo struct {
+	Channel string `json:"channel"`
+	Code    string `json:"code"`
+}
+
+// Marks the user's email or mobile number verified with a one-time code
+func confirmVerification(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	var info ConfirmVerificationInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if !checkChannel(w, r, info.Channel) {
+		return
+	}
+
+	user, ok := ensureSelfUser(w, r, reqId)
+	if !ok {
+		return
+	}
+
+	var codeId, expiresAt int64
+	var destination, codeHash string
+	err := db.QueryRow(`SELECT id, destination, codeHash, expiresAt
+		FROM verification_code
+		WHERE userId = ? AND channel = ? AND consumedAt IS NULL
+		ORDER BY id DESC LIMIT 1`,
+		reqId, info.Channel,
+	).Scan(&codeId, &destination, &codeHash, &expiresAt)
+	if err == sql.ErrNoRows {
+		writeError(w, r, "No code was requested. Please request a new code.")
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("confirmVerification: Error in query" + err.Error())
+		return
+	}
+
+	// Codes are for the contact details they were sent to
+	if time.Now().Unix() >= expiresAt || destination != channelDestination(user, info.Channel) {
+		writeError(w, r, "The code has expired. Please request a new code.")
+		return
+	}
+
+	// Count the attempt before checking, so concurrent guesses are limited too
+	res, err := db.Exec(`UPDATE verification_code SET attempts = attempts + 1
+		WHERE id = ? AND attempts < ?`, codeId, maxVerificationAttempts)
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("confirmVerification: Error in exec" + err.Error())
+		return
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		writeError(w, r, "DB err 4")
+		return
+	}
+	if count == 0 {
+		writeErrorStatus(w, r, "Too many wrong codes. Please request a new code.", http.StatusTooManyRequests)
+		return
+	}
+
+	if !hmac.Equal([]byte(hashCode(info.Code)), []byte(codeHash)) {
+		writeError(w, r, "Incorrect code")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeError(w, r, "DB err 5")
+		log.Println("confirmVerification: Error in begin" + err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	res, err = tx.Exec("UPDATE verification_code SET consumedAt = ? WHERE id = ? AND consumedAt IS NULL",
+		time.Now().Unix(), codeId)
+	if err != nil {
+		writeError(w, r, "DB err 6")
+		log.Println("confirmVerification: Error in exec" + err.Error())
+		return
+	}
+
+	// Another request may have used the code in the meantime
+	count, err = res.RowsAffected()
+	if err != nil || count == 0 {
+		writeError(w, r, "The code was already used. Please request a new code.")
+		return
+	}
+
+	column := "verifiedEmail"
+	if info.Channel == ChannelMobile {
+		column = "verifiedMobileNo"
+	}
+	_, err = tx.Exec("UPDATE user SET "+column+" = ? WHERE id = ?", destination, reqId)
+	if err != nil {
+		writeError(w, r, "DB err 7")
+		log.Println("confirmVerification: Error in exec" + err.Error())
+		return
+	}
+
+	err = accounts.audit(tx, reqId, column, nil, &destination, callerIdentity(r))
+	if err != nil {
+		writeError(w, r, "DB err 8")
+		log.Println("confirmVerification: Error in audit" + err.Error())
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		writeError(w, r, "DB err 9")
+		log.Println("confirmVerification: Error in commit" + err.Error())
+		return
+	}
+
+	getUser(w, r)
+}
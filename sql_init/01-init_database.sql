@@ -25,6 +25,17 @@ USE `etia1account`;
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `credential`
+--
+
+CREATE TABLE `credential` (
+  `userId` int(11) NOT NULL,
+  `passwordHash` varchar(255) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `driver`
 --
@@ -32,7 +43,26 @@ USE `etia1account`;
 CREATE TABLE `driver` (
   `userId` int(11) NOT NULL,
   `identificationNo` varchar(127) NOT NULL,
-  `carNo` varchar(127) NOT NULL
+  `carNo` varchar(127) NOT NULL,
+  `status` varchar(31) NOT NULL DEFAULT 'pending',
+  `statusUpdatedAt` bigint(20) DEFAULT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `driver_document`
+--
+
+CREATE TABLE `driver_document` (
+  `id` int(11) NOT NULL,
+  `driverId` int(11) NOT NULL,
+  `type` varchar(31) NOT NULL,
+  `blobKey` varchar(255) NOT NULL,
+  `contentType` varchar(127) NOT NULL,
+  `size` int(11) NOT NULL,
+  `expiresOn` date DEFAULT NULL,
+  `uploadedAt` bigint(20) NOT NULL
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 -- --------------------------------------------------------
@@ -47,6 +77,81 @@ CREATE TABLE `passenger` (
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `password_reset`
+--
+
+CREATE TABLE `password_reset` (
+  `id` int(11) NOT NULL,
+  `userId` int(11) NOT NULL,
+  `channel` varchar(31) NOT NULL,
+  `tokenHash` char(64) NOT NULL,
+  `createdAt` bigint(20) NOT NULL,
+  `expiresAt` bigint(20) NOT NULL,
+  `consumedAt` bigint(20) DEFAULT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `saved_place`
+--
+
+CREATE TABLE `saved_place` (
+  `id` int(11) NOT NULL,
+  `passengerId` int(11) NOT NULL,
+  `label` varchar(127) NOT NULL,
+  `postalCode` varchar(127) NOT NULL,
+  `unitNo` varchar(127) NOT NULL DEFAULT '',
+  `notes` varchar(255) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `session`
+--
+
+CREATE TABLE `session` (
+  `id` int(11) NOT NULL,
+  `userId` int(11) NOT NULL,
+  `role` varchar(31) NOT NULL,
+  `refreshTokenHash` char(64) NOT NULL,
+  `expiresAt` bigint(20) NOT NULL,
+  `revokedAt` bigint(20) DEFAULT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `staff`
+--
+
+CREATE TABLE `staff` (
+  `userId` int(11) NOT NULL,
+  `role` varchar(31) NOT NULL,
+  `grantedAt` bigint(20) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `suspension`
+--
+
+CREATE TABLE `suspension` (
+  `id` int(11) NOT NULL,
+  `userId` int(11) NOT NULL,
+  `reason` varchar(255) NOT NULL,
+  `expiresAt` bigint(20) DEFAULT NULL,
+  `createdAt` bigint(20) NOT NULL,
+  `createdBy` int(11) DEFAULT NULL,
+  `liftedAt` bigint(20) DEFAULT NULL,
+  `liftedBy` int(11) DEFAULT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `user`
 --
@@ -56,18 +161,89 @@ CREATE TABLE `user` (
   `firstName` varchar(127) NOT NULL,
   `lastName` varchar(127) NOT NULL,
   `mobileNo` varchar(127) NOT NULL,
-  `email` varchar(127) NOT NULL
+  `email` varchar(127) NOT NULL,
+  `verifiedEmail` varchar(127) DEFAULT NULL,
+  `verifiedMobileNo` varchar(127) DEFAULT NULL,
+  `deletedAt` bigint(20) DEFAULT NULL
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='passenger info';
 
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `user_audit`
+--
+
+CREATE TABLE `user_audit` (
+  `id` int(11) NOT NULL,
+  `userId` int(11) NOT NULL,
+  `field` varchar(63) NOT NULL,
+  `oldValue` varchar(255) DEFAULT NULL,
+  `newValue` varchar(255) DEFAULT NULL,
+  `actorId` int(11) DEFAULT NULL,
+  `actorRole` varchar(31) DEFAULT NULL,
+  `createdAt` bigint(20) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `vehicle`
+--
+
+CREATE TABLE `vehicle` (
+  `id` int(11) NOT NULL,
+  `driverId` int(11) NOT NULL,
+  `plate` varchar(127) NOT NULL,
+  `make` varchar(127) NOT NULL,
+  `model` varchar(127) NOT NULL,
+  `colour` varchar(127) NOT NULL,
+  `seats` int(11) NOT NULL,
+  `vehicleClass` varchar(31) NOT NULL,
+  `active` tinyint(1) NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `verification_code`
+--
+
+CREATE TABLE `verification_code` (
+  `id` int(11) NOT NULL,
+  `userId` int(11) NOT NULL,
+  `channel` varchar(31) NOT NULL,
+  `destination` varchar(127) NOT NULL,
+  `codeHash` char(64) NOT NULL,
+  `attempts` int(11) NOT NULL DEFAULT 0,
+  `createdAt` bigint(20) NOT NULL,
+  `expiresAt` bigint(20) NOT NULL,
+  `consumedAt` bigint(20) DEFAULT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 --
 -- Indexes for dumped tables
 --
 
+--
+-- Indexes for table `credential`
+--
+ALTER TABLE `credential`
+  ADD PRIMARY KEY (`userId`);
+
 --
 -- Indexes for table `driver`
 --
 ALTER TABLE `driver`
-  ADD PRIMARY KEY (`userId`);
+  ADD PRIMARY KEY (`userId`),
+  ADD UNIQUE KEY `identificationNo` (`identificationNo`),
+  ADD KEY `status` (`status`);
+
+--
+-- Indexes for table `driver_document`
+--
+ALTER TABLE `driver_document`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `driverId` (`driverId`,`type`);
 
 --
 -- Indexes for table `passenger`
@@ -75,37 +251,205 @@ ALTER TABLE `driver`
 ALTER TABLE `passenger`
   ADD PRIMARY KEY (`userId`);
 
+--
+-- Indexes for table `password_reset`
+--
+ALTER TABLE `password_reset`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `tokenHash` (`tokenHash`),
+  ADD KEY `userId` (`userId`);
+
+--
+-- Indexes for table `saved_place`
+--
+ALTER TABLE `saved_place`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `label` (`passengerId`,`label`);
+
+--
+-- Indexes for table `session`
+--
+ALTER TABLE `session`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `refreshTokenHash` (`refreshTokenHash`),
+  ADD KEY `userId` (`userId`);
+
+--
+-- Indexes for table `staff`
+--
+ALTER TABLE `staff`
+  ADD PRIMARY KEY (`userId`);
+
+--
+-- Indexes for table `suspension`
+--
+ALTER TABLE `suspension`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `userId` (`userId`);
+
 --
 -- Indexes for table `user`
 --
 ALTER TABLE `user`
-  ADD PRIMARY KEY (`id`);
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `email` (`email`),
+  ADD UNIQUE KEY `mobileNo` (`mobileNo`);
+
+--
+-- Indexes for table `user_audit`
+--
+ALTER TABLE `user_audit`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `userId` (`userId`);
+
+--
+-- Indexes for table `vehicle`
+--
+ALTER TABLE `vehicle`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `plate` (`plate`),
+  ADD KEY `driverId` (`driverId`);
+
+--
+-- Indexes for table `verification_code`
+--
+ALTER TABLE `verification_code`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `userId` (`userId`,`channel`);
 
 --
 -- AUTO_INCREMENT for dumped tables
 --
 
+--
+-- AUTO_INCREMENT for table `driver_document`
+--
+ALTER TABLE `driver_document`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `password_reset`
+--
+ALTER TABLE `password_reset`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `saved_place`
+--
+ALTER TABLE `saved_place`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `session`
+--
+ALTER TABLE `session`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `suspension`
+--
+ALTER TABLE `suspension`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
 --
 -- AUTO_INCREMENT for table `user`
 --
 ALTER TABLE `user`
   MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
 
+--
+-- AUTO_INCREMENT for table `user_audit`
+--
+ALTER TABLE `user_audit`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `vehicle`
+--
+ALTER TABLE `vehicle`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `verification_code`
+--
+ALTER TABLE `verification_code`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
 --
 -- Constraints for dumped tables
 --
 
+--
+-- Constraints for table `credential`
+--
+ALTER TABLE `credential`
+  ADD CONSTRAINT `credential_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
+
 --
 -- Constraints for table `driver`
 --
 ALTER TABLE `driver`
   ADD CONSTRAINT `driver_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
 
+--
+-- Constraints for table `driver_document`
+--
+ALTER TABLE `driver_document`
+  ADD CONSTRAINT `driver_document_ibfk_1` FOREIGN KEY (`driverId`) REFERENCES `driver` (`userId`);
+
 --
 -- Constraints for table `passenger`
 --
 ALTER TABLE `passenger`
   ADD CONSTRAINT `passenger_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
+
+--
+-- Constraints for table `password_reset`
+--
+ALTER TABLE `password_reset`
+  ADD CONSTRAINT `password_reset_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
+
+--
+-- Constraints for table `saved_place`
+--
+ALTER TABLE `saved_place`
+  ADD CONSTRAINT `saved_place_ibfk_1` FOREIGN KEY (`passengerId`) REFERENCES `passenger` (`userId`);
+
+--
+-- Constraints for table `session`
+--
+ALTER TABLE `session`
+  ADD CONSTRAINT `session_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
+
+--
+-- Constraints for table `staff`
+--
+ALTER TABLE `staff`
+  ADD CONSTRAINT `staff_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
+
+--
+-- Constraints for table `suspension`
+--
+ALTER TABLE `suspension`
+  ADD CONSTRAINT `suspension_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
+
+--
+-- Constraints for table `user_audit`
+--
+ALTER TABLE `user_audit`
+  ADD CONSTRAINT `user_audit_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
+
+--
+-- Constraints for table `vehicle`
+--
+ALTER TABLE `vehicle`
+  ADD CONSTRAINT `vehicle_ibfk_1` FOREIGN KEY (`driverId`) REFERENCES `driver` (`userId`);
+
+--
+-- Constraints for table `verification_code`
+--
+ALTER TABLE `verification_code`
+  ADD CONSTRAINT `verification_code_ibfk_1` FOREIGN KEY (`userId`) REFERENCES `user` (`id`);
 --
 -- Database: `etia1tripmanagement`
 --
@@ -132,8 +476,9 @@ CREATE TABLE `ongoing_trip` (
   `id` int(11) NOT NULL,
   `postalCode` varchar(127) NOT NULL,
   `passengerId` int(11) NOT NULL,
-  `driverId` int(11) NOT NULL,
-  `startTime` bigint(20) DEFAULT NULL
+  `driverId` int(11) DEFAULT NULL,
+  `startTime` bigint(20) DEFAULT NULL,
+  `status` enum('requested','driver_assigned','driver_arrived','in_progress') NOT NULL
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 -- --------------------------------------------------------
@@ -151,6 +496,39 @@ CREATE TABLE `trip_history` (
   `endTIme` bigint(20) NOT NULL
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `trip_offer`
+--
+
+CREATE TABLE `trip_offer` (
+  `id` int(11) NOT NULL,
+  `tripId` int(11) NOT NULL,
+  `driverId` int(11) NOT NULL,
+  `status` enum('pending','accepted','declined','expired','withdrawn') NOT NULL,
+  `createdAt` bigint(20) NOT NULL,
+  `expiresAt` bigint(20) NOT NULL,
+  `respondedAt` bigint(20) DEFAULT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `trip_rating`
+--
+
+CREATE TABLE `trip_rating` (
+  `id` int(11) NOT NULL,
+  `tripId` int(11) NOT NULL,
+  `raterRole` varchar(31) NOT NULL,
+  `raterId` int(11) NOT NULL,
+  `rateeId` int(11) NOT NULL,
+  `rating` tinyint(4) NOT NULL,
+  `comment` varchar(1023) NOT NULL DEFAULT '',
+  `createdAt` bigint(20) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 --
 -- Indexes for dumped tables
 --
@@ -177,6 +555,23 @@ ALTER TABLE `trip_history`
   ADD KEY `passengerId` (`passengerId`),
   ADD KEY `driverId` (`driverId`);
 
+--
+-- Indexes for table `trip_offer`
+--
+ALTER TABLE `trip_offer`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `tripId` (`tripId`),
+  ADD KEY `driverId` (`driverId`,`status`),
+  ADD KEY `status` (`status`,`expiresAt`);
+
+--
+-- Indexes for table `trip_rating`
+--
+ALTER TABLE `trip_rating`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `tripId` (`tripId`,`raterRole`),
+  ADD KEY `rateeId` (`rateeId`,`raterRole`);
+
 --
 -- AUTO_INCREMENT for dumped tables
 --
@@ -186,6 +581,28 @@ ALTER TABLE `trip_history`
 --
 ALTER TABLE `ongoing_trip`
   MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `trip_offer`
+--
+ALTER TABLE `trip_offer`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `trip_rating`
+--
+ALTER TABLE `trip_rating`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- Constraints for dumped tables
+--
+
+--
+-- Constraints for table `trip_rating`
+--
+ALTER TABLE `trip_rating`
+  ADD CONSTRAINT `trip_rating_ibfk_1` FOREIGN KEY (`tripId`) REFERENCES `trip_history` (`id`);
 COMMIT;
 
 /*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
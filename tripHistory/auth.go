@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Used only when SLEDAWAY_TOKEN_SECRET is not set, e.g. local development.
+// Must be the same secret accountManagement signs tokens with.
+const devTokenSecret = "sledaway-development-secret"
+
+const (
+	RolePassenger = "passenger"
+	RoleDriver    = "driver"
+)
+
+var tokenSecret []byte
+
+// Loads the token signing secret from the environment
+func loadTokenSecret() {
+	secret := os.Getenv("SLEDAWAY_TOKEN_SECRET")
+	if secret == "" {
+		log.Println("SLEDAWAY_TOKEN_SECRET is not set, using the development secret")
+		secret = devTokenSecret
+	}
+	tokenSecret = []byte(secret)
+}
+
+// Claims carried by an access token issued by accountManagement. The subject
+// is the user id.
+type AccessClaims struct {
+	Role      string `json:"role"`
+	SessionId int64  `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// The authenticated caller of a request
+type Identity struct {
+	UserId int64
+	Role   string
+}
+
+type identityKey struct{}
+
+// Middleware that validates the bearer access token of the request and
+// stores the caller identity in the request context
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			writeErrorStatus(w, r, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		var claims AccessClaims
+		_, err := jwt.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), &claims,
+			func(t *jwt.Token) (interface{}, error) {
+				return tokenSecret, nil
+			}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err != nil {
+			writeErrorStatus(w, r, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		userId, err := strconv.ParseInt(claims.Subject, 10, 64)
+		if err != nil {
+			writeErrorStatus(w, r, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		// Service tokens have no session
+		if claims.Role != RoleService {
+			err = sessions.Check(claims.SessionId, header)
+			if err == errSessionRevoked {
+				writeErrorStatus(w, r, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				writeErrorStatus(w, r, "Could not check session", http.StatusBadGateway)
+				log.Println("authenticate: Error in session" + err.Error())
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), identityKey{}, Identity{
+			UserId: userId,
+			Role:   claims.Role,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Gets the caller identity stored by authenticate
+func callerIdentity(r *http.Request) Identity {
+	identity, _ := r.Context().Value(identityKey{}).(Identity)
+	return identity
+}
+
+// Ensures the caller is signed in with the role and is the user with the id.
+// An id of 0 is filled in with the caller's own id.
+func ensureCaller(w http.ResponseWriter, r *http.Request, role string, id *int64) bool {
+	identity := callerIdentity(r)
+	if identity.Role != role {
+		writeErrorStatus(w, r, "Only a "+role+" may do this", http.StatusForbidden)
+		return false
+	}
+
+	if *id == 0 {
+		*id = identity.UserId
+	} else if *id != identity.UserId {
+		writeErrorStatus(w, r, "You may not act on behalf of another "+role, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Same as ensureCaller, for an id given in the request path
+func ensureCallerPath(w http.ResponseWriter, r *http.Request, role string, reqId string) bool {
+	id, err := strconv.ParseInt(reqId, 10, 64)
+	if err != nil || id == 0 {
+		writeErrorStatus(w, r, "Invalid id: "+reqId, http.StatusBadRequest)
+		return false
+	}
+	return ensureCaller(w, r, role, &id)
+}
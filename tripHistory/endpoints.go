@@ -15,6 +15,12 @@ import (
 
 var db *sql.DB
 
+const accountManagementApiUrl = "http://localhost:21801"
+
+// Client for calls to the other services, so a service that hangs cannot
+// hold up requests here
+var serviceClient = &http.Client{Timeout: 5 * time.Second}
+
 // --------------
 // Structures and common function
 // --------------
@@ -35,6 +41,16 @@ func writeError(w http.ResponseWriter, r *http.Request, description string) {
 	})
 }
 
+// Writes a regular JSON error response, with a status code
+func writeErrorStatus(w http.ResponseWriter, r *http.Request, description string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(RegularResponse{
+		Status:      false,
+		Description: description,
+	})
+}
+
 // Ensures that the request is a json and converts it
 func ensureJson(w http.ResponseWriter, r *http.Request, v interface{}) error {
 	if r.Header.Get("Content-type") != "application/json" {
@@ -88,6 +104,10 @@ type GetPasssengerTripsResponse struct {
 func getPasssengerTrips(w http.ResponseWriter, r *http.Request) {
 	reqPassengerId := mux.Vars(r)["passengerId"]
 
+	if !ensureCallerPathOr(w, r, RolePassenger, reqPassengerId, PermReadHistory) {
+		return
+	}
+
 	stmt, err := db.Prepare(`SELECT
 		id, postalCode, passengerId, driverId, startTime, endTIme
 		FROM trip_history
@@ -134,6 +154,11 @@ func addTripLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !hasPermission(callerIdentity(r), PermWriteHistory) {
+		writeErrorStatus(w, r, "Only tripManagement may log trips", http.StatusForbidden)
+		return
+	}
+
 	timestamp := time.Now().Unix()
 
 	stmt, err := db.Prepare(`INSERT INTO trip_history
@@ -145,6 +170,19 @@ func addTripLog(w http.ResponseWriter, r *http.Request) {
 	}
 	_, err = stmt.Exec(info.Id, info.PostalCode, info.PassengerId,
 		info.DriverId, info.StartTime, timestamp)
+
+	// The same trip may be sent again if tripManagement failed to remove it
+	// after it was logged
+	if isDuplicate(err) {
+		err = db.QueryRow(`SELECT endTIme FROM trip_history
+			WHERE id = ? AND passengerId = ? AND driverId = ? AND startTime = ?`,
+			info.Id, info.PassengerId, info.DriverId, info.StartTime,
+		).Scan(&timestamp)
+		if err == sql.ErrNoRows {
+			writeErrorStatus(w, r, "Another trip is logged with the id", http.StatusConflict)
+			return
+		}
+	}
 	if err != nil {
 		writeError(w, r, "DB err 2")
 		log.Println("addTripLog: Error in exec" + err.Error())
@@ -170,11 +208,21 @@ func registerEndpoints(db1 *sql.DB) *mux.Router {
 
 	router.HandleFunc("/api/v1", home)
 
+	// Every other route requires a signed in user
+	api := router.NewRoute().Subrouter()
+	api.Use(authenticate)
+
 	// Gets all history of trips of a passenger
-	router.HandleFunc("/api/v1/passengerTrips/{passengerId}", getPasssengerTrips).Methods("GET")
+	api.HandleFunc("/api/v1/passengerTrips/{passengerId}", getPasssengerTrips).Methods("GET")
 	// Adds a new trip log
 	// TODO: This could be an RPC call instead.
-	router.HandleFunc("/api/v1/tripsLog", addTripLog).Methods("POST")
+	api.HandleFunc("/api/v1/tripsLog", addTripLog).Methods("POST")
+
+	// Ratings of archived trips
+	api.HandleFunc("/api/v1/trips/{id}/ratings", getTripRatings).Methods("GET")
+	api.HandleFunc("/api/v1/trips/{id}/ratings", rateTrip).Methods("POST")
+	// Summaries are public, e.g. for profiles
+	router.HandleFunc("/api/v1/ratings/{role}", getRatingSummaries).Methods("GET")
 
 	return router
 }
@@ -13,6 +13,8 @@ import (
 const PORT = 21802
 
 func main() {
+	loadTokenSecret()
+
 	db, err := sql.Open("mysql", "root@tcp(127.0.0.1:3306)/etia1tripmanagement")
 
 	// handle error
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Role of tokens other services issue to call on their own behalf
+const RoleService = "service"
+
+// Permissions to act on resources other than the caller's own
+const (
+	PermReadHistory  = "history:read"
+	PermWriteHistory = "history:write"
+)
+
+// Permissions of each role used by this service. Must match accountManagement.
+var rolePermissions = map[string][]string{
+	"support":   {PermReadHistory},
+	"admin":     {PermReadHistory},
+	RoleService: {PermWriteHistory},
+}
+
+// Checks whether the caller's role grants the permission
+func hasPermission(identity Identity, permission string) bool {
+	for _, p := range rolePermissions[identity.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Same as ensureCallerPath, but callers with the permission may act on any
+// user
+func ensureCallerPathOr(w http.ResponseWriter, r *http.Request, role string, reqId string, permission string) bool {
+	if hasPermission(callerIdentity(r), permission) {
+		return true
+	}
+	return ensureCallerPath(w, r, role, reqId)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// Limits of a rating
+const (
+	minRating        = 1
+	maxRating        = 5
+	maxCommentLength = 1000
+)
+
+// Most users whose ratings can be summarized in a request
+const maxRatingSummaryIds = 100
+
+// Whether the error is from inserting a row which unique key already exists
+func isDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// --------------
+
+type RatingInfo struct {
+	Id        int64  `json:"id"`
+	TripId    int64  `json:"tripId"`
+	RaterRole string `json:"raterRole"`
+	RaterId   int64  `json:"raterId"`
+	RateeId   int64  `json:"rateeId"`
+	Rating    int    `json:"rating"`
+	Comment   string `json:"comment"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+type RateTripInfo struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+// Rates the other side of an archived trip. Passengers rate the driver and
+// drivers rate the passenger, once per trip.
+func rateTrip(w http.ResponseWriter, r *http.Request) {
+	tripReqId := mux.Vars(r)["id"]
+
+	var info RateTripInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	info.Comment = strings.TrimSpace(info.Comment)
+	if info.Rating < minRating || info.Rating > maxRating {
+		writeErrorStatus(w, r, "Rating must be from 1 to 5", http.StatusUnprocessableEntity)
+		return
+	}
+	if len([]rune(info.Comment)) > maxCommentLength {
+		writeErrorStatus(w, r, "Comment must be at most "+strconv.Itoa(maxCommentLength)+" characters", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var passengerId, driverId int64
+	err := db.QueryRow("SELECT passengerId, driverId FROM trip_history WHERE id = ?", tripReqId).
+		Scan(&passengerId, &driverId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Trip not found: "+tripReqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("rateTrip: Error in query" + err.Error())
+		return
+	}
+
+	identity := callerIdentity(r)
+	rating := RatingInfo{
+		RaterRole: identity.Role,
+		RaterId:   identity.UserId,
+		Rating:    info.Rating,
+		Comment:   info.Comment,
+		CreatedAt: time.Now().Unix(),
+	}
+	switch {
+	case identity.Role == RolePassenger && identity.UserId == passengerId:
+		rating.RateeId = driverId
+	case identity.Role == RoleDriver && identity.UserId == driverId:
+		rating.RateeId = passengerId
+	default:
+		writeErrorStatus(w, r, "Only the passenger and driver of the trip may rate it", http.StatusForbidden)
+		return
+	}
+	rating.TripId, _ = strconv.ParseInt(tripReqId, 10, 64)
+
+	res, err := db.Exec(`INSERT INTO trip_rating
+		(tripId, raterRole, raterId, rateeId, rating, comment, createdAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rating.TripId, rating.RaterRole, rating.RaterId, rating.RateeId,
+		rating.Rating, rating.Comment, rating.CreatedAt)
+	if isDuplicate(err) {
+		writeErrorStatus(w, r, "You have already rated this trip", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("rateTrip: Error in exec" + err.Error())
+		return
+	}
+
+	rating.Id, err = res.LastInsertId()
+	if err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("rateTrip: Error in last id" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(rating)
+}
+
+type GetTripRatingsResponse struct {
+	Ratings []RatingInfo `json:"ratings"`
+}
+
+// Gets the ratings of a trip, for its passenger and driver
+func getTripRatings(w http.ResponseWriter, r *http.Request) {
+	tripReqId := mux.Vars(r)["id"]
+
+	var passengerId, driverId int64
+	err := db.QueryRow("SELECT passengerId, driverId FROM trip_history WHERE id = ?", tripReqId).
+		Scan(&passengerId, &driverId)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Trip not found: "+tripReqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("getTripRatings: Error in query" + err.Error())
+		return
+	}
+
+	identity := callerIdentity(r)
+	if !(identity.Role == RolePassenger && identity.UserId == passengerId) &&
+		!(identity.Role == RoleDriver && identity.UserId == driverId) &&
+		!hasPermission(identity, PermReadHistory) {
+		writeErrorStatus(w, r, "Only the passenger and driver of the trip may see its ratings", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.Query(`SELECT
+		id, tripId, raterRole, raterId, rateeId, rating, comment, createdAt
+		FROM trip_rating
+		WHERE tripId = ?
+		ORDER BY id`, tripReqId)
+	if err != nil {
+		writeError(w, r, "DB err 2")
+		log.Println("getTripRatings: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := GetTripRatingsResponse{
+		Ratings: []RatingInfo{},
+	}
+	for rows.Next() {
+		var info RatingInfo
+		err = rows.Scan(
+			&info.Id, &info.TripId, &info.RaterRole, &info.RaterId,
+			&info.RateeId, &info.Rating, &info.Comment, &info.CreatedAt,
+		)
+		if err != nil {
+			writeError(w, r, "DB err 3")
+			log.Println("getTripRatings: Error in scan" + err.Error())
+			return
+		}
+		resp.Ratings = append(resp.Ratings, info)
+	}
+	if err = rows.Err(); err != nil {
+		writeError(w, r, "DB err 4")
+		log.Println("getTripRatings: Error in rows" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Aggregate of the ratings a user received. Average is null without ratings.
+type RatingSummary struct {
+	Average *float64 `json:"average"`
+	Count   int64    `json:"count"`
+}
+
+type GetRatingSummariesResponse struct {
+	Ratings map[string]RatingSummary `json:"ratings"`
+}
+
+// Gets the rating summaries of the drivers or passengers with the ids given
+// as a comma separated list, e.g. ?ids=1,2,3
+func getRatingSummaries(w http.ResponseWriter, r *http.Request) {
+	// Drivers are rated by passengers and the other way round
+	var raterRole string
+	switch mux.Vars(r)["role"] {
+	case "drivers":
+		raterRole = RolePassenger
+	case "passengers":
+		raterRole = RoleDriver
+	default:
+		writeErrorStatus(w, r, "Unknown role: "+mux.Vars(r)["role"], http.StatusNotFound)
+		return
+	}
+
+	resp := GetRatingSummariesResponse{
+		Ratings: map[string]RatingSummary{},
+	}
+	var ids []interface{}
+	for _, value := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeError(w, r, "Invalid id: "+value)
+			return
+		}
+		ids = append(ids, id)
+		resp.Ratings[strconv.FormatInt(id, 10)] = RatingSummary{}
+	}
+	if len(ids) > maxRatingSummaryIds {
+		writeError(w, r, "At most "+strconv.Itoa(maxRatingSummaryIds)+" ids may be given")
+		return
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := db.Query(`SELECT rateeId, AVG(rating), COUNT(*)
+		FROM trip_rating
+		WHERE raterRole = ? AND rateeId IN (`+placeholders+`)
+		GROUP BY rateeId`, append([]interface{}{raterRole}, ids...)...)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("getRatingSummaries: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rateeId int64
+		var summary RatingSummary
+		err = rows.Scan(&rateeId, &summary.Average, &summary.Count)
+		if err != nil {
+			writeError(w, r, "DB err 2")
+			log.Println("getRatingSummaries: Error in scan" + err.Error())
+			return
+		}
+		resp.Ratings[strconv.FormatInt(rateeId, 10)] = summary
+	}
+	if err = rows.Err(); err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("getRatingSummaries: Error in rows" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
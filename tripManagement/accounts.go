@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const accountManagementApiUrl = "http://localhost:21801"
+
+// Onboarding status of a driver that may take trips
+const DriverApproved = "approved"
+
+// Returned when accountManagement does not know the account
+var errAccountNotFound = errors.New("account not found")
+
+// Makes a GET request to accountManagement as this service
+func serviceGet(url string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := issueServiceToken()
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	return serviceClient.Do(request)
+}
+
+// A suspension of an account, as described by accountManagement
+type SuspensionInfo struct {
+	Reason    string `json:"reason"`
+	ExpiresAt *int64 `json:"expiresAt"`
+}
+
+// A driver, as described by accountManagement
+type DriverAccount struct {
+	Status     string          `json:"status"`
+	Suspension *SuspensionInfo `json:"suspension"`
+}
+
+// A passenger, as described by accountManagement
+type PassengerAccount struct {
+	EmailVerified  bool            `json:"emailVerified"`
+	MobileVerified bool            `json:"mobileVerified"`
+	Suspension     *SuspensionInfo `json:"suspension"`
+}
+
+// Gets the onboarding status and suspension of a driver from
+// accountManagement
+func fetchDriver(driverId int64) (DriverAccount, error) {
+	var driver DriverAccount
+	resp, err := serviceGet(accountManagementApiUrl + "/api/v1/drivers/" + strconv.FormatInt(driverId, 10))
+	if err != nil {
+		return driver, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return driver, errAccountNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return driver, errors.New("unexpected status from accountManagement: " + resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&driver)
+	return driver, err
+}
+
+// Gets whether a passenger has verified their email and mobile number, and
+// their suspension, from accountManagement
+func fetchPassenger(passengerId int64) (PassengerAccount, error) {
+	var passenger PassengerAccount
+	resp, err := serviceGet(accountManagementApiUrl + "/api/v1/passengers/" + strconv.FormatInt(passengerId, 10))
+	if err != nil {
+		return passenger, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return passenger, errAccountNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return passenger, errors.New("unexpected status from accountManagement: " + resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&passenger)
+	return passenger, err
+}
+
+// A passenger's saved place, as described by accountManagement
+type PlaceInfo struct {
+	Id         int64  `json:"id"`
+	Label      string `json:"label"`
+	PostalCode string `json:"postalCode"`
+	UnitNo     string `json:"unitNo"`
+	Notes      string `json:"notes"`
+}
+
+// Gets a saved place of the passenger from accountManagement. The caller's
+// access token is forwarded, so only their own places can be used.
+func fetchPlace(r *http.Request, passengerId int64, placeId int64) (PlaceInfo, error) {
+	var place PlaceInfo
+	request, err := http.NewRequest(http.MethodGet, accountManagementApiUrl+"/api/v1/passengers/"+
+		strconv.FormatInt(passengerId, 10)+"/places/"+strconv.FormatInt(placeId, 10), nil)
+	if err != nil {
+		return place, err
+	}
+
+	request.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+	resp, err := serviceClient.Do(request)
+	if err != nil {
+		return place, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return place, errAccountNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return place, errors.New("unexpected status from accountManagement: " + resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&place)
+	return place, err
+}
+
+// A driver's vehicle, as described by accountManagement
+type VehicleInfo struct {
+	Plate        string `json:"plate"`
+	Make         string `json:"make"`
+	Model        string `json:"model"`
+	Colour       string `json:"colour"`
+	Seats        int    `json:"seats"`
+	VehicleClass string `json:"vehicleClass"`
+}
+
+// Gets the active vehicle of a driver from accountManagement. Returns nil if
+// the driver has none.
+func fetchActiveVehicle(driverId int64) (*VehicleInfo, error) {
+	resp, err := serviceGet(accountManagementApiUrl + "/api/v1/drivers/" + strconv.FormatInt(driverId, 10) + "/vehicles/active")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status from accountManagement: " + resp.Status)
+	}
+
+	var vehicle VehicleInfo
+	if err = json.NewDecoder(resp.Body).Decode(&vehicle); err != nil {
+		return nil, err
+	}
+	return &vehicle, nil
+}
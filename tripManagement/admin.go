@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type OngoingTripInfo struct {
+	TripId      int64  `json:"tripId"`
+	PostalCode  string `json:"postalCode"`
+	PassengerId int64  `json:"passengerId"`
+	DriverId    *int64 `json:"driverId"`
+	StartTime   *int64 `json:"startTime"`
+	Status      string `json:"status"`
+}
+
+type ListOngoingTripsResponse struct {
+	Trips []OngoingTripInfo `json:"trips"`
+}
+
+// Lists every ongoing trip, oldest first
+func listOngoingTrips(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT
+		id, postalCode, passengerId, driverId, startTime, status
+		FROM ongoing_trip
+		ORDER BY id`)
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("listOngoingTrips: Error in query" + err.Error())
+		return
+	}
+	defer rows.Close()
+
+	resp := ListOngoingTripsResponse{
+		Trips: []OngoingTripInfo{},
+	}
+	for rows.Next() {
+		var info OngoingTripInfo
+		err = rows.Scan(&info.TripId, &info.PostalCode, &info.PassengerId, &info.DriverId, &info.StartTime, &info.Status)
+		if err != nil {
+			writeError(w, r, "DB err 2")
+			log.Println("listOngoingTrips: Error in scan" + err.Error())
+			return
+		}
+		resp.Trips = append(resp.Trips, info)
+	}
+	if err = rows.Err(); err != nil {
+		writeError(w, r, "DB err 3")
+		log.Println("listOngoingTrips: Error in rows" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Ends a trip regardless of which driver is assigned to it, e.g. when the
+// driver can no longer end it themselves. Trips in progress are completed
+// and archived, and trips that have not started are cancelled.
+func forceEndTrip(w http.ResponseWriter, r *http.Request) {
+	tripReqId := mux.Vars(r)["id"]
+
+	err := finishTrip(tripReqId, nil)
+	var transitionErr *TransitionError
+	if errors.As(err, &transitionErr) {
+		_, err = transitionTrip(tripReqId, TripCancelled, nil)
+	}
+	if writeTransitionError(w, r, tripReqId, err) || writeArchiveError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "Could not end trip")
+		log.Println("forceEndTrip: Error in finish" + err.Error())
+		return
+	}
+
+	log.Println("forceEndTrip: Trip", tripReqId, "ended by user", callerIdentity(r).UserId)
+}
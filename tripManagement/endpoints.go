@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -19,6 +20,11 @@ var db *sql.DB
 
 const tripHistoryApiUrl = "http://localhost:21802"
 
+// Client for calls to the other services, so a service that hangs cannot
+// hold up requests here. accountManagement may wait on tripHistory before
+// answering, so this allows longer than its own client.
+var serviceClient = &http.Client{Timeout: 10 * time.Second}
+
 // --------------
 // Structures and common function
 // --------------
@@ -27,8 +33,18 @@ const tripHistoryApiUrl = "http://localhost:21802"
 type RegularResponse struct {
 	Status      bool   `json:"status"`
 	Description string `json:"description"`
+	// Machine readable reason for some errors
+	Code string `json:"code,omitempty"`
 }
 
+// Error codes given in a RegularResponse
+const (
+	CodeAccountSuspended  = "account_suspended"
+	CodeNoDriverNearby    = "no_driver_nearby"
+	CodeInvalidTransition = "invalid_transition"
+	CodeOfferExpired      = "offer_expired"
+)
+
 // Writes a regular JSON error response
 func writeError(w http.ResponseWriter, r *http.Request, description string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -49,6 +65,26 @@ func writeErrorStatus(w http.ResponseWriter, r *http.Request, description string
 	})
 }
 
+// Writes a regular JSON error response, with a status code and error code
+func writeErrorCode(w http.ResponseWriter, r *http.Request, description string, statusCode int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(RegularResponse{
+		Status:      false,
+		Description: description,
+		Code:        code,
+	})
+}
+
+// Writes that the caller's account is suspended
+func writeSuspended(w http.ResponseWriter, r *http.Request, suspension *SuspensionInfo) {
+	description := "Your account is suspended: " + suspension.Reason
+	if suspension.ExpiresAt != nil {
+		description += " (until " + time.Unix(*suspension.ExpiresAt, 0).UTC().Format(time.RFC3339) + ")"
+	}
+	writeErrorCode(w, r, description, http.StatusForbidden, CodeAccountSuspended)
+}
+
 // Ensures that the request is a json and converts it
 func ensureJson(w http.ResponseWriter, r *http.Request, v interface{}) error {
 	if r.Header.Get("Content-type") != "application/json" {
@@ -85,16 +121,93 @@ func home(w http.ResponseWriter, r *http.Request) {
 // --------------
 
 type CreateTripInfo struct {
-	// Note: normally this would be retrieved or verified from an authentication
-	// service based on the client's auth token, but for the sake of simplicity
-	// we'll trust this info directly from the client
+	// Optional, taken from the caller's access token if omitted
 	PassengerId int64  `json:"passengerId"`
 	PostalCode  string `json:"postalCode"`
+	// A saved place of the passenger, in lieu of the postal code
+	PlaceId int64 `json:"placeId"`
+}
+
+// Returned when no driver near the pickup can take a trip
+var errNoDriverNearby = errors.New("no driver nearby")
+
+// Number of nearest available drivers to consider for a trip
+const driverCandidates = 10
+
+// An available driver and how far they are from the pickup
+type driverCandidate struct {
+	driverId   int64
+	distanceKm float64
+}
+
+// Gets the nearest available driver within the search radius of the pickup,
+// that is NOT currently in an ongoing trip or considering an offer, is not
+// excluded and is approved to take trips.
+// Drivers are found with their last known location, so available drivers
+// that have stopped sending it are not matched. Drivers that are no longer
+// approved or are suspended are removed from the available drivers.
+func findNearestDriver(pickup Position, excluded map[int64]bool) (int64, error) {
+	nearby := locations.Nearby(pickup, searchRadiusKm)
+	if len(nearby) == 0 {
+		return 0, errNoDriverNearby
+	}
+
+	args := make([]interface{}, len(nearby))
+	for i, candidate := range nearby {
+		args[i] = candidate.driverId
+	}
+	rows, err := db.Query(`
+		SELECT ad.driverId FROM available_driver ad
+		LEFT JOIN ongoing_trip ot ON ad.driverId = ot.driverId
+		LEFT JOIN trip_offer o ON ad.driverId = o.driverId AND o.status = ?
+		WHERE ot.driverId IS NULL AND o.id IS NULL
+		AND ad.driverId IN (?`+strings.Repeat(", ?", len(nearby)-1)+`)
+	`, append([]interface{}{OfferPending}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	available := map[int64]bool{}
+	for rows.Next() {
+		var driverId int64
+		if err = rows.Scan(&driverId); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		available[driverId] = true
+	}
+	rows.Close()
+
+	// Nearby drivers are already sorted by distance
+	var candidates []driverCandidate
+	for _, candidate := range nearby {
+		if available[candidate.driverId] && !excluded[candidate.driverId] && len(candidates) < driverCandidates {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	for _, candidate := range candidates {
+		driver, err := fetchDriver(candidate.driverId)
+		if err != nil && err != errAccountNotFound {
+			log.Println("findNearestDriver: Error in status" + err.Error())
+			continue
+		}
+		if driver.Status == DriverApproved && driver.Suspension == nil {
+			return candidate.driverId, nil
+		}
+
+		_, err = db.Exec("DELETE FROM available_driver WHERE driverId = ?", candidate.driverId)
+		if err != nil {
+			log.Println("findNearestDriver: Error in exec" + err.Error())
+		}
+	}
+
+	return 0, errNoDriverNearby
 }
 
 type CreateTripResponse struct {
-	Id       int64 `json:"id"`
-	DriverId int64 `json:"driverId"`
+	Id     int64  `json:"id"`
+	Status string `json:"status"`
 }
 
 func createTrip(w http.ResponseWriter, r *http.Request) {
@@ -103,34 +216,85 @@ func createTrip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !ensureCaller(w, r, RolePassenger, &info.PassengerId) {
+		return
+	}
+
+	if (info.PostalCode == "") == (info.PlaceId == 0) {
+		writeError(w, r, "Expected either postalCode or placeId")
+		return
+	}
+
 	log.Println(info)
 
-	// Get random available driver, that is NOT currently in an ongoing trip
-	var driverId int64
-	err := db.QueryRow(`
-		SELECT ad.driverId FROM available_driver ad
-		LEFT JOIN ongoing_trip ot ON ad.driverId = ot.driverId
-		WHERE ot.driverId IS NULL
-		ORDER BY RAND()
-		LIMIT 1;
-	`).Scan(&driverId)
+	if info.PlaceId != 0 {
+		place, err := fetchPlace(r, info.PassengerId, info.PlaceId)
+		if err == errAccountNotFound {
+			writeErrorStatus(w, r, "Place not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeErrorStatus(w, r, "Could not get place", http.StatusBadGateway)
+			log.Println("createTrip: Error in place query" + err.Error())
+			return
+		}
+		info.PostalCode = place.PostalCode
+	}
+
+	passenger, err := fetchPassenger(info.PassengerId)
+	if err == errAccountNotFound {
+		writeErrorStatus(w, r, "Passenger not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		writeErrorStatus(w, r, "No available driver for your trip. Please try again.", http.StatusNotFound)
+		writeErrorStatus(w, r, "Could not check passenger", http.StatusBadGateway)
+		log.Println("createTrip: Error in passenger query" + err.Error())
+		return
+	}
+	if passenger.Suspension != nil {
+		writeSuspended(w, r, passenger.Suspension)
+		return
+	}
+	if !passenger.EmailVerified || !passenger.MobileVerified {
+		writeErrorStatus(w, r, "Please verify your email and mobile number before requesting a trip", http.StatusForbidden)
 		return
 	}
 
-	// Insert ongoing_trip table
-	stmt, err := db.Prepare(`
-		INSERT INTO
-		ongoing_trip (postalCode, passengerId, driverId)
-		VALUES (?, ?, ?)
-	`)
+	pickup, err := geocoder.Locate(info.PostalCode)
+	if err == errUnknownPostalCode {
+		writeError(w, r, "Unknown postal code: "+info.PostalCode)
+		return
+	}
+	if err != nil {
+		writeErrorStatus(w, r, "Could not locate postal code", http.StatusBadGateway)
+		log.Println("createTrip: Error in geocode" + err.Error())
+		return
+	}
+
+	driverId, err := findNearestDriver(pickup, nil)
+	if err == errNoDriverNearby {
+		writeErrorCode(w, r, "No driver nearby for your trip. Please try again later.", http.StatusNotFound, CodeNoDriverNearby)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 4")
+		log.Println("createTrip: Error in driver query" + err.Error())
+		return
+	}
+
+	// Insert ongoing_trip table, and offer the trip to the driver
+	tx, err := db.Begin()
 	if err != nil {
 		writeError(w, r, "DB err 1")
 		return
 	}
+	defer tx.Rollback()
 
-	res, err := stmt.Exec(info.PostalCode, info.PassengerId, driverId)
+	res, err := tx.Exec(`
+		INSERT INTO
+		ongoing_trip (postalCode, passengerId, status)
+		VALUES (?, ?, ?)
+	`, info.PostalCode, info.PassengerId, TripRequested)
 	if err != nil {
 		writeError(w, r, "DB err 2")
 		log.Println("createTrip: Error in exec" + err.Error())
@@ -144,16 +308,30 @@ func createTrip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = offerTrip(tx, id, driverId)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err == errDriverTaken {
+		writeErrorStatus(w, r, "The nearest driver was just matched with another trip. Please try again.", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 5")
+		log.Println("createTrip: Error in offer" + err.Error())
+		return
+	}
+
+	dispatch{tripId: id, passengerId: info.PassengerId, driverId: driverId}.notify()
+
 	json.NewEncoder(w).Encode(CreateTripResponse{
-		Id:       id,
-		DriverId: driverId,
+		Id:     id,
+		Status: TripRequested,
 	})
 }
 
 type AcceptTripInfo struct {
-	// Note: normally this would be retrieved or verified from an authentication
-	// service based on the client's auth token, but for the sake of simplicity
-	// we'll trust this info directly from the client
+	// Optional, taken from the caller's access token if omitted
 	DriverId int64 `json:"driverId"`
 }
 
@@ -161,8 +339,7 @@ type AcceptTripResponse struct {
 	StartTime int64 `json:"startTime"`
 }
 
-// 1. Sets driver not available
-// 2. Add start timestamp
+// Starts a trip once the passenger is picked up
 func acceptTrip(w http.ResponseWriter, r *http.Request) {
 	tripReqId := mux.Vars(r)["id"]
 
@@ -171,48 +348,29 @@ func acceptTrip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Println(info)
-
-	// Update start time in ongoing_trip table
-	stmt, err := db.Prepare(`
-		UPDATE ongoing_trip
-		SET startTime = ?
-		WHERE id = ?
-	`)
-	if err != nil {
-		writeError(w, r, "DB err 1")
+	if !ensureCaller(w, r, RoleDriver, &info.DriverId) {
 		return
 	}
 
-	timestamp := time.Now().Unix()
-	res, err := stmt.Exec(timestamp, tripReqId)
-	if err != nil {
-		writeError(w, r, "DB err 2")
-		log.Println("createTrip: Error in exec" + err.Error())
-		return
-	}
+	log.Println(info)
 
-	count, err := res.RowsAffected()
-	if err != nil {
-		writeError(w, r, "DB err 3")
+	trip, err := transitionTrip(tripReqId, TripInProgress, assignedDriverGuard(info.DriverId))
+	if writeTransitionError(w, r, tripReqId, err) {
 		return
 	}
-
-	// Check if any rows were updated
-	if count == 0 {
-		writeError(w, r, "No records were updated"+strconv.Itoa(int(timestamp)))
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("acceptTrip: Error in transition" + err.Error())
 		return
 	}
 
 	json.NewEncoder(w).Encode(AcceptTripResponse{
-		StartTime: timestamp,
+		StartTime: *trip.StartTime,
 	})
 }
 
 type EndTripInfo struct {
-	// Note: normally this would be retrieved or verified from an authentication
-	// service based on the client's auth token, but for the sake of simplicity
-	// we'll trust this info directly from the client
+	// Optional, taken from the caller's access token if omitted
 	DriverId int64 `json:"driverId"`
 }
 
@@ -226,8 +384,90 @@ type TripHistoryInfo struct {
 	EndTime     int64
 }
 
-// 2. Rmv ongoing_trip record
-// 3. Call tripHistory to archive trip
+// Returned when tripHistory could not archive a completed trip
+type ArchiveError struct {
+	Err error
+}
+
+func (e *ArchiveError) Error() string {
+	return "could not archive trip: " + e.Err.Error()
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// Writes the error of finishTrip if the trip could not be archived, returning
+// whether it was written
+func writeArchiveError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) {
+		return false
+	}
+	writeErrorStatus(w, r, "Could not archive trip, it is still ongoing", http.StatusBadGateway)
+	log.Println("writeArchiveError: Error in archive" + archiveErr.Err.Error())
+	return true
+}
+
+// Completes the ongoing trip, archiving it with tripHistory and removing it.
+// The trip is only removed once archived, so it is still ongoing and can be
+// ended again if archiving fails. Returns the errors of transitionTrip, or an
+// *ArchiveError.
+func finishTrip(tripId string, guard tripGuard) error {
+	// The trip is not kept locked while tripHistory is called, so it is
+	// checked again when it is removed. tripHistory accepts the same trip
+	// again if it is ended twice in the meantime.
+	trip, err := checkTransition(tripId, TripCompleted, guard)
+	if err != nil {
+		return err
+	}
+
+	if err = archiveTrip(trip); err != nil {
+		return &ArchiveError{Err: err}
+	}
+
+	_, err = transitionTrip(tripId, TripCompleted, guard)
+	return err
+}
+
+// Sends a completed trip to tripHistory
+func archiveTrip(trip OngoingTrip) error {
+	tripHist := TripHistoryInfo{
+		Id:          trip.Id,
+		PostalCode:  trip.PostalCode,
+		PassengerId: trip.PassengerId,
+		DriverId:    trip.DriverId,
+		StartTime:   *trip.StartTime,
+	}
+
+	// Call tripHistory to archive trip
+	jsonValue, _ := json.Marshal(tripHist)
+
+	request, err := http.NewRequest(http.MethodPost,
+		tripHistoryApiUrl+"/api/v1/tripsLog", bytes.NewBuffer(jsonValue))
+	if err != nil {
+		return err
+	}
+
+	token, err := issueServiceToken()
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := serviceClient.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected status from tripHistory: " + resp.Status)
+	}
+	return nil
+}
+
 func endTrip(w http.ResponseWriter, r *http.Request) {
 	tripReqId := mux.Vars(r)["id"]
 
@@ -236,95 +476,157 @@ func endTrip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 3.1. Save info
-	var tripHist TripHistoryInfo
+	if !ensureCaller(w, r, RoleDriver, &info.DriverId) {
+		return
+	}
 
-	stmt, err := db.Prepare(`
-		SELECT id, postalCode, passengerId, driverId, startTime
-		FROM ongoing_trip
-		WHERE id = ?
-	`)
-	if err != nil {
-		writeError(w, r, "DB err 1")
+	err := finishTrip(tripReqId, assignedDriverGuard(info.DriverId))
+	if writeTransitionError(w, r, tripReqId, err) || writeArchiveError(w, r, err) {
 		return
 	}
-	err = stmt.QueryRow(tripReqId).Scan(
-		&tripHist.Id, &tripHist.PostalCode,
-		&tripHist.PassengerId, &tripHist.DriverId,
-		&tripHist.StartTime,
-	)
 	if err != nil {
-		writeError(w, r, "Trip not found: "+tripReqId)
+		writeError(w, r, "Could not end trip")
+		log.Println("endTrip: Error in finish" + err.Error())
 		return
 	}
+}
 
-	// 2. Rmv ongoing_trip record
-	stmt, err = db.Prepare(`
-		DELETE FROM ongoing_trip
-		WHERE id = ?
-	`)
+// --------------
+
+type GetDriverTripResponse struct {
+	TripId      int64        `json:"tripId"`
+	PostalCode  string       `json:"postalCode"`
+	PassengerId int64        `json:"passengerId"`
+	Status      string       `json:"status"`
+	Vehicle     *VehicleInfo `json:"vehicle"`
+}
+
+func getDriverTrip(w http.ResponseWriter, r *http.Request) {
+	var resp GetDriverTripResponse
+	reqId := mux.Vars(r)["id"]
+
+	if !ensureCallerPathOr(w, r, RoleDriver, reqId, PermReadTrips) {
+		return
+	}
+
+	stmt, err := db.Prepare(`SELECT
+		id, postalCode, passengerId, status
+		FROM ongoing_trip
+		WHERE driverId = ?`)
 	if err != nil {
-		writeError(w, r, "DB err 3")
+		writeError(w, r, "DB err 1")
 		return
 	}
-	_, err = stmt.Exec(tripReqId)
+
+	err = stmt.QueryRow(reqId).Scan(&resp.TripId, &resp.PostalCode, &resp.PassengerId, &resp.Status)
 	if err != nil {
-		writeError(w, r, "DB err 4")
-		log.Println("endTrip: Error in exec" + err.Error())
+		writeErrorStatus(w, r, "Driver is not assigned to any trip: "+reqId, http.StatusNotFound)
 		return
 	}
 
-	// 3.2 Call tripHistory to archive trip
-	jsonValue, _ := json.Marshal(tripHist)
+	// The vehicle is optional, the trip is still usable without it
+	driverId, _ := strconv.ParseInt(reqId, 10, 64)
+	resp.Vehicle, err = fetchActiveVehicle(driverId)
+	if err != nil {
+		log.Println("getDriverTrip: Error in vehicle" + err.Error())
+	}
 
-	request, err := http.NewRequest(http.MethodPost,
-		tripHistoryApiUrl+"/api/v1/tripsLog", bytes.NewBuffer(jsonValue))
+	json.NewEncoder(w).Encode(resp)
+}
 
-	request.Header.Set("Content-Type", "application/json")
+type GetPassengerTripResponse struct {
+	TripId     int64  `json:"tripId"`
+	PostalCode string `json:"postalCode"`
+	// Not known until a driver accepts the trip
+	DriverId  *int64       `json:"driverId"`
+	StartTime *int64       `json:"startTime"`
+	Status    string       `json:"status"`
+	Vehicle   *VehicleInfo `json:"vehicle"`
+}
 
-	client := &http.Client{}
-	_, err = client.Do(request)
+func getPassengerTrip(w http.ResponseWriter, r *http.Request) {
+	var resp GetPassengerTripResponse
+	reqId := mux.Vars(r)["id"]
 
+	if !ensureCallerPathOr(w, r, RolePassenger, reqId, PermReadTrips) {
+		return
+	}
+
+	stmt, err := db.Prepare(`SELECT
+		id, postalCode, driverId, startTime, status
+		FROM ongoing_trip
+		WHERE passengerId = ?`)
 	if err != nil {
-		writeError(w, r, "Could not save trip log: "+err.Error())
+		writeError(w, r, "DB err 1")
+		return
 	}
-}
 
-// --------------
+	err = stmt.QueryRow(reqId).Scan(&resp.TripId, &resp.PostalCode, &resp.DriverId, &resp.StartTime, &resp.Status)
+	if err != nil {
+		writeErrorStatus(w, r, "Passenger is not in any trip: "+reqId, http.StatusNotFound)
+		return
+	}
 
-type GetDriverTripResponse struct {
+	// So the passenger knows which car to look for
+	if resp.DriverId != nil {
+		resp.Vehicle, err = fetchActiveVehicle(*resp.DriverId)
+		if err != nil {
+			log.Println("getPassengerTrip: Error in vehicle" + err.Error())
+		}
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+type GetUserTripResponse struct {
 	TripId      int64  `json:"tripId"`
 	PostalCode  string `json:"postalCode"`
 	PassengerId int64  `json:"passengerId"`
+	DriverId    *int64 `json:"driverId"`
+	StartTime   *int64 `json:"startTime"`
+	Status      string `json:"status"`
 }
 
-func getDriverTrip(w http.ResponseWriter, r *http.Request) {
-	var resp GetDriverTripResponse
+// Gets the ongoing trip of a user as either a passenger or driver
+func getUserTrip(w http.ResponseWriter, r *http.Request) {
+	var resp GetUserTripResponse
 	reqId := mux.Vars(r)["id"]
 
+	if !ensureSelfPathOr(w, r, reqId, PermReadTrips) {
+		return
+	}
+
 	stmt, err := db.Prepare(`SELECT
-		id, postalCode, passengerId
+		id, postalCode, passengerId, driverId, startTime, status
 		FROM ongoing_trip
-		WHERE driverId = ?`)
+		WHERE passengerId = ? OR driverId = ?
+		LIMIT 1`)
 	if err != nil {
 		writeError(w, r, "DB err 1")
 		return
 	}
 
-	err = stmt.QueryRow(reqId).Scan(&resp.TripId, &resp.PostalCode, &resp.PassengerId)
+	err = stmt.QueryRow(reqId, reqId).Scan(
+		&resp.TripId, &resp.PostalCode, &resp.PassengerId,
+		&resp.DriverId, &resp.StartTime, &resp.Status,
+	)
 	if err != nil {
-		writeErrorStatus(w, r, "Driver is not assigned to any trip: "+reqId, http.StatusNotFound)
+		writeErrorStatus(w, r, "User is not in any trip: "+reqId, http.StatusNotFound)
 		return
 	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
+// --------------
+
 type SetAvailableDriverInfo struct {
-	// Note: normally this would be retrieved or verified from an authentication
-	// service based on the client's auth token, but for the sake of simplicity
-	// we'll trust this info directly from the client
+	// Optional, taken from the caller's access token if omitted
 	DriverId int64 `json:"driverId"`
+	// Current position of the driver, optional if the driver has recently
+	// sent their location
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
 }
 
 func setAvailableDriver(w http.ResponseWriter, r *http.Request) {
@@ -333,29 +635,50 @@ func setAvailableDriver(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Println(info)
+	if !ensureCaller(w, r, RoleDriver, &info.DriverId) {
+		return
+	}
 
-	// Check if driver found
+	_, located := locations.Get(info.DriverId)
+	hasPosition := info.Latitude != nil || info.Longitude != nil
+	if (hasPosition || !located) && !validPosition(info.Latitude, info.Longitude) {
+		writeError(w, r, "Expected the latitude and longitude of the driver")
+		return
+	}
 
-	stmt, err := db.Prepare(`
-		SELECT driverId FROM available_driver
-		WHERE driverId = ?
-	`)
+	log.Println(info)
+
+	// Only approved drivers that are not suspended may take trips
+	driver, err := fetchDriver(info.DriverId)
+	if err == errAccountNotFound {
+		writeErrorStatus(w, r, "Driver not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		writeError(w, r, "DB err 1")
+		writeErrorStatus(w, r, "Could not check driver status", http.StatusBadGateway)
+		log.Println("setAvailableDriver: Error in status" + err.Error())
 		return
 	}
-	var driverId int64
-	err = stmt.QueryRow(info.DriverId).Scan(&driverId)
-	if err == nil {
-		// driver found, do nothing
-		w.WriteHeader(http.StatusNotModified)
+	if driver.Suspension != nil {
+		writeSuspended(w, r, driver.Suspension)
+		return
+	}
+	if driver.Status != DriverApproved {
+		writeErrorStatus(w, r, "Driver is not approved to take trips: "+driver.Status, http.StatusForbidden)
 		return
 	}
 
-	// Insert  table
-	stmt, err = db.Prepare(`
-		INSERT INTO
+	if hasPosition {
+		locations.Update(DriverLocation{
+			DriverId:  info.DriverId,
+			Position:  Position{Latitude: *info.Latitude, Longitude: *info.Longitude},
+			UpdatedAt: time.Now().Unix(),
+		})
+	}
+
+	// Insert table, unless already available
+	stmt, err := db.Prepare(`
+		INSERT IGNORE INTO
 		available_driver (driverId)
 		VALUES (?)
 	`)
@@ -375,6 +698,10 @@ func setAvailableDriver(w http.ResponseWriter, r *http.Request) {
 func getAvailableDriver(w http.ResponseWriter, r *http.Request) {
 	reqId := mux.Vars(r)["id"]
 
+	if !ensureCallerPathOr(w, r, RoleDriver, reqId, PermReadTrips) {
+		return
+	}
+
 	// Check if driver found
 	stmt, err := db.Prepare(`
 		SELECT driverId FROM available_driver
@@ -397,6 +724,10 @@ func getAvailableDriver(w http.ResponseWriter, r *http.Request) {
 func deleteAvailableDriver(w http.ResponseWriter, r *http.Request) {
 	reqId := mux.Vars(r)["id"]
 
+	if !ensureCallerPath(w, r, RoleDriver, reqId) {
+		return
+	}
+
 	// Check if driver found
 	stmt, err := db.Prepare(`
 		DELETE FROM available_driver
@@ -428,21 +759,52 @@ func registerEndpoints(db1 *sql.DB) *mux.Router {
 
 	router.HandleFunc("/api/v1", home)
 
+	// Every other route requires a signed in user
+	api := router.NewRoute().Subrouter()
+	api.Use(authenticate)
+
 	// Adds a trip request and assigns an available driver to it
-	router.HandleFunc("/api/v1/trips", createTrip).Methods("POST")
+	api.HandleFunc("/api/v1/trips", createTrip).Methods("POST")
 	// Starts a trip
-	router.HandleFunc("/api/v1/trips/{id}", acceptTrip).Methods("POST")
+	api.HandleFunc("/api/v1/trips/{id}", acceptTrip).Methods("POST")
 	// Ends a trip
-	router.HandleFunc("/api/v1/trips/{id}", endTrip).Methods("DELETE")
+	api.HandleFunc("/api/v1/trips/{id}", endTrip).Methods("DELETE")
+	// Accepts the offer of a trip
+	api.HandleFunc("/api/v1/trips/{id}/offer/accept", acceptOffer).Methods("POST")
+	// Declines the offer of a trip
+	api.HandleFunc("/api/v1/trips/{id}/offer/decline", declineOffer).Methods("POST")
+	// Marks that the driver has arrived at the pickup
+	api.HandleFunc("/api/v1/trips/{id}/arrived", arriveAtTrip).Methods("POST")
+	// Cancels a trip that has not started
+	api.HandleFunc("/api/v1/trips/{id}/cancel", cancelTrip).Methods("POST")
+
+	// Gets the ongoing trip of the passenger
+	api.HandleFunc("/api/v1/passenger/{id}/trip", getPassengerTrip).Methods("GET")
+
+	// Gets the ongoing trip of the user, in any role
+	api.HandleFunc("/api/v1/users/{id}/trip", getUserTrip).Methods("GET")
 
 	// Sets driver as available
-	router.HandleFunc("/api/v1/driver", setAvailableDriver).Methods("POST")
+	api.HandleFunc("/api/v1/driver", setAvailableDriver).Methods("POST")
 	// Gets whether the driver is available
-	router.HandleFunc("/api/v1/driver/{id}", getAvailableDriver).Methods("GET")
+	api.HandleFunc("/api/v1/driver/{id}", getAvailableDriver).Methods("GET")
 	// Gets assigned trip for the driver
-	router.HandleFunc("/api/v1/driver/{id}/trip", getDriverTrip).Methods("GET")
+	api.HandleFunc("/api/v1/driver/{id}/trip", getDriverTrip).Methods("GET")
+	// Gets the pending trip offer of the driver
+	api.HandleFunc("/api/v1/driver/{id}/offer", getDriverOffer).Methods("GET")
+	// Records the current location of the driver
+	api.HandleFunc("/api/v1/driver/{id}/location", updateDriverLocation).Methods("POST")
+	// Gets the last known location of the driver
+	api.HandleFunc("/api/v1/driver/{id}/location", getDriverLocation).Methods("GET")
 	// Removes driver from available
-	router.HandleFunc("/api/v1/driver/{id}", deleteAvailableDriver).Methods("DELETE")
+	api.HandleFunc("/api/v1/driver/{id}", deleteAvailableDriver).Methods("DELETE")
+
+	// Staff only routes
+	admin := api.PathPrefix("/api/v1/admin").Subrouter()
+	// Lists every ongoing trip
+	admin.HandleFunc("/trips", requirePermission(PermReadTrips, listOngoingTrips)).Methods("GET")
+	// Ends a trip on behalf of its driver
+	admin.HandleFunc("/trips/{id}", requirePermission(PermManageTrips, forceEndTrip)).Methods("DELETE")
 
 	return router
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Default radius around the pickup to look for drivers in
+const defaultSearchRadiusKm = 5.0
+
+const earthRadiusKm = 6371.0
+
+// A point on the map in degrees
+type Position struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Gets the great-circle distance between two positions
+func distanceKm(a Position, b Position) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// Gets the latitude and longitude differences that span the radius around
+// the position, for finding positions within a bounding box
+func boundingBox(center Position, radiusKm float64) (dLat float64, dLng float64) {
+	dLat = radiusKm / earthRadiusKm * 180 / math.Pi
+	dLng = dLat / math.Cos(center.Latitude*math.Pi/180)
+	return dLat, dLng
+}
+
+// Returned when a postal code cannot be resolved to a position
+var errUnknownPostalCode = errors.New("unknown postal code")
+
+// Resolves postal codes to positions
+type Geocoder interface {
+	Locate(postalCode string) (Position, error)
+}
+
+var geocoder Geocoder
+
+// Radius around the pickup to look for drivers in
+var searchRadiusKm = defaultSearchRadiusKm
+
+// Sets up the geocoder and search radius. Postal codes are resolved with the
+// CSV file of postalCode,latitude,longitude rows in SLEDAWAY_POSTAL_CODES_FILE,
+// falling back to the centre of their postal district. The search radius is
+// taken from SLEDAWAY_SEARCH_RADIUS_KM.
+func loadGeocoder() {
+	if value := os.Getenv("SLEDAWAY_SEARCH_RADIUS_KM"); value != "" {
+		radius, err := strconv.ParseFloat(value, 64)
+		if err != nil || radius <= 0 {
+			log.Fatal("SLEDAWAY_SEARCH_RADIUS_KM must be a positive number of kilometres")
+		}
+		searchRadiusKm = radius
+	}
+
+	postalGeocoder := postalCodeGeocoder{positions: map[string]Position{}}
+	path := os.Getenv("SLEDAWAY_POSTAL_CODES_FILE")
+	if path == "" {
+		log.Println("SLEDAWAY_POSTAL_CODES_FILE is not set, locating postal codes by district")
+	} else if err := postalGeocoder.load(path); err != nil {
+		log.Fatal("Could not load SLEDAWAY_POSTAL_CODES_FILE: " + err.Error())
+	}
+	geocoder = postalGeocoder
+}
+
+// Locates Singapore postal codes with a list of known postal codes, or else
+// by the first two digits, the postal sector
+type postalCodeGeocoder struct {
+	positions map[string]Position
+}
+
+// Reads postalCode,latitude,longitude rows after a header row
+func (g postalCodeGeocoder) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	if _, err = reader.Read(); err != nil {
+		return err
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		latitude, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return err
+		}
+		longitude, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return err
+		}
+		g.positions[record[0]] = Position{Latitude: latitude, Longitude: longitude}
+	}
+}
+
+func (g postalCodeGeocoder) Locate(postalCode string) (Position, error) {
+	if len(postalCode) != 6 {
+		return Position{}, errUnknownPostalCode
+	}
+	if position, ok := g.positions[postalCode]; ok {
+		return position, nil
+	}
+	if position, ok := postalSectorPositions[postalCode[:2]]; ok {
+		return position, nil
+	}
+	return Position{}, errUnknownPostalCode
+}
+
+// Approximate centre of the postal district of each postal sector
+var postalSectorPositions = func() map[string]Position {
+	districts := []struct {
+		sectors  []string
+		position Position
+	}{
+		// Raffles Place, Cecil, Marina
+		{[]string{"01", "02", "03", "04", "05", "06"}, Position{1.2840, 103.8510}},
+		// Anson, Tanjong Pagar
+		{[]string{"07", "08"}, Position{1.2764, 103.8458}},
+		// Queenstown, Tiong Bahru
+		{[]string{"14", "15", "16"}, Position{1.2905, 103.8100}},
+		// Telok Blangah, Harbourfront
+		{[]string{"09", "10"}, Position{1.2705, 103.8190}},
+		// Pasir Panjang, Clementi New Town
+		{[]string{"11", "12", "13"}, Position{1.2950, 103.7750}},
+		// High Street, Beach Road
+		{[]string{"17"}, Position{1.2950, 103.8520}},
+		// Middle Road, Golden Mile
+		{[]string{"18", "19"}, Position{1.3010, 103.8590}},
+		// Little India
+		{[]string{"20", "21"}, Position{1.3070, 103.8520}},
+		// Orchard, River Valley
+		{[]string{"22", "23"}, Position{1.3030, 103.8320}},
+		// Ardmore, Bukit Timah, Holland Road
+		{[]string{"24", "25", "26", "27"}, Position{1.3160, 103.8030}},
+		// Watten Estate, Novena, Thomson
+		{[]string{"28", "29", "30"}, Position{1.3250, 103.8380}},
+		// Balestier, Toa Payoh, Serangoon
+		{[]string{"31", "32", "33"}, Position{1.3300, 103.8520}},
+		// Macpherson, Braddell
+		{[]string{"34", "35", "36", "37"}, Position{1.3350, 103.8780}},
+		// Geylang, Eunos
+		{[]string{"38", "39", "40", "41"}, Position{1.3180, 103.8920}},
+		// Katong, Joo Chiat, Amber Road
+		{[]string{"42", "43", "44", "45"}, Position{1.3050, 103.9050}},
+		// Bedok, Upper East Coast
+		{[]string{"46", "47", "48"}, Position{1.3240, 103.9300}},
+		// Loyang, Changi
+		{[]string{"49", "50", "81"}, Position{1.3600, 103.9800}},
+		// Tampines, Pasir Ris
+		{[]string{"51", "52"}, Position{1.3600, 103.9450}},
+		// Serangoon Garden, Hougang, Punggol
+		{[]string{"53", "54", "55", "82"}, Position{1.3750, 103.8930}},
+		// Bishan, Ang Mo Kio
+		{[]string{"56", "57"}, Position{1.3620, 103.8450}},
+		// Upper Bukit Timah, Clementi Park
+		{[]string{"58", "59"}, Position{1.3400, 103.7750}},
+		// Jurong, Tuas
+		{[]string{"60", "61", "62", "63", "64"}, Position{1.3350, 103.7100}},
+		// Hillview, Bukit Panjang, Choa Chu Kang
+		{[]string{"65", "66", "67", "68"}, Position{1.3750, 103.7600}},
+		// Lim Chu Kang, Tengah
+		{[]string{"69", "70", "71"}, Position{1.4100, 103.7200}},
+		// Kranji, Woodlands
+		{[]string{"72", "73"}, Position{1.4350, 103.7750}},
+		// Upper Thomson, Springleaf
+		{[]string{"77", "78"}, Position{1.3950, 103.8200}},
+		// Yishun, Sembawang
+		{[]string{"75", "76"}, Position{1.4350, 103.8300}},
+		// Seletar
+		{[]string{"79", "80"}, Position{1.4000, 103.8700}},
+	}
+
+	positions := map[string]Position{}
+	for _, district := range districts {
+		for _, sector := range district.sectors {
+			positions[sector] = district.position
+		}
+	}
+	return positions
+}()
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Locations not updated for this long are no longer known
+const locationLifetime = 2 * time.Minute
+
+// How often expired locations are removed from the index
+const locationExpiryInterval = 30 * time.Second
+
+// Size of a cell of the location grid in degrees, about 1.1 km
+const locationCellSize = 0.01
+
+// The last known location of a driver
+type DriverLocation struct {
+	DriverId int64 `json:"driverId"`
+	Position
+	// Optional direction of travel in degrees clockwise from north
+	Heading *float64 `json:"heading"`
+	// Optional speed in km/h
+	Speed     *float64 `json:"speed"`
+	UpdatedAt int64    `json:"updatedAt"`
+}
+
+func (l DriverLocation) expired(now time.Time) bool {
+	return now.Sub(time.Unix(l.UpdatedAt, 0)) >= locationLifetime
+}
+
+type gridCell struct {
+	lat int
+	lng int
+}
+
+func cellOf(position Position) gridCell {
+	return gridCell{
+		lat: int(math.Floor(position.Latitude / locationCellSize)),
+		lng: int(math.Floor(position.Longitude / locationCellSize)),
+	}
+}
+
+// Keeps the locations of drivers in memory, bucketed into a grid of cells
+// so drivers near a position can be found without checking every driver.
+// Safe for concurrent use.
+type locationIndex struct {
+	mu        sync.RWMutex
+	locations map[int64]DriverLocation
+	cells     map[gridCell]map[int64]struct{}
+}
+
+var locations = newLocationIndex()
+
+func newLocationIndex() *locationIndex {
+	return &locationIndex{
+		locations: map[int64]DriverLocation{},
+		cells:     map[gridCell]map[int64]struct{}{},
+	}
+}
+
+// Sets the location of a driver, moving them to another cell if needed
+func (index *locationIndex) Update(location DriverLocation) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.remove(location.DriverId)
+	index.locations[location.DriverId] = location
+
+	cell := cellOf(location.Position)
+	if index.cells[cell] == nil {
+		index.cells[cell] = map[int64]struct{}{}
+	}
+	index.cells[cell][location.DriverId] = struct{}{}
+}
+
+// Gets the location of a driver, unless it is not known or expired
+func (index *locationIndex) Get(driverId int64) (DriverLocation, bool) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	location, ok := index.locations[driverId]
+	if !ok || location.expired(time.Now()) {
+		return DriverLocation{}, false
+	}
+	return location, true
+}
+
+// Gets the drivers within the radius of the center, nearest first
+func (index *locationIndex) Nearby(center Position, radiusKm float64) []driverCandidate {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	now := time.Now()
+	dLat, dLng := boundingBox(center, radiusKm)
+	min := cellOf(Position{Latitude: center.Latitude - dLat, Longitude: center.Longitude - dLng})
+	max := cellOf(Position{Latitude: center.Latitude + dLat, Longitude: center.Longitude + dLng})
+
+	var candidates []driverCandidate
+	for lat := min.lat; lat <= max.lat; lat++ {
+		for lng := min.lng; lng <= max.lng; lng++ {
+			for driverId := range index.cells[gridCell{lat: lat, lng: lng}] {
+				location := index.locations[driverId]
+				if location.expired(now) {
+					continue
+				}
+
+				distance := distanceKm(center, location.Position)
+				if distance <= radiusKm {
+					candidates = append(candidates, driverCandidate{driverId: driverId, distanceKm: distance})
+				}
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].distanceKm < candidates[j].distanceKm
+	})
+	return candidates
+}
+
+// Removes every expired location
+func (index *locationIndex) Expire() {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	now := time.Now()
+	for driverId, location := range index.locations {
+		if location.expired(now) {
+			index.remove(driverId)
+		}
+	}
+}
+
+// Removes the driver. Must hold the lock.
+func (index *locationIndex) remove(driverId int64) {
+	location, ok := index.locations[driverId]
+	if !ok {
+		return
+	}
+	delete(index.locations, driverId)
+
+	cell := cellOf(location.Position)
+	delete(index.cells[cell], driverId)
+	if len(index.cells[cell]) == 0 {
+		delete(index.cells, cell)
+	}
+}
+
+// Removes expired locations in the background
+func startLocationExpiry() {
+	go func() {
+		for range time.Tick(locationExpiryInterval) {
+			locations.Expire()
+		}
+	}()
+}
+
+// --------------
+
+type UpdateLocationInfo struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Heading   *float64 `json:"heading"`
+	Speed     *float64 `json:"speed"`
+}
+
+// Checks the position of a location, returning whether it is valid
+func validPosition(latitude *float64, longitude *float64) bool {
+	return latitude != nil && longitude != nil &&
+		math.Abs(*latitude) <= 90 && math.Abs(*longitude) <= 180
+}
+
+// Records the current location of the signed in driver. Drivers are expected
+// to send this every few seconds while signed in.
+func updateDriverLocation(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	var info UpdateLocationInfo
+	if ensureJson(w, r, &info) != nil {
+		return
+	}
+
+	if !ensureCallerPath(w, r, RoleDriver, reqId) {
+		return
+	}
+
+	if !validPosition(info.Latitude, info.Longitude) {
+		writeError(w, r, "Expected the latitude and longitude of the driver")
+		return
+	}
+	if info.Heading != nil && (*info.Heading < 0 || *info.Heading >= 360) {
+		writeError(w, r, "Expected a heading from 0 to 360 degrees")
+		return
+	}
+	if info.Speed != nil && *info.Speed < 0 {
+		writeError(w, r, "Expected a speed of at least 0 km/h")
+		return
+	}
+
+	driverId, _ := strconv.ParseInt(reqId, 10, 64)
+	location := DriverLocation{
+		DriverId:  driverId,
+		Position:  Position{Latitude: *info.Latitude, Longitude: *info.Longitude},
+		Heading:   info.Heading,
+		Speed:     info.Speed,
+		UpdatedAt: time.Now().Unix(),
+	}
+	locations.Update(location)
+
+	json.NewEncoder(w).Encode(location)
+}
+
+// Gets the last known location of a driver. Only the driver, the passenger
+// of their ongoing trip and staff may see it.
+func getDriverLocation(w http.ResponseWriter, r *http.Request) {
+	reqId := mux.Vars(r)["id"]
+
+	driverId, err := strconv.ParseInt(reqId, 10, 64)
+	if err != nil || driverId == 0 {
+		writeErrorStatus(w, r, "Invalid id: "+reqId, http.StatusBadRequest)
+		return
+	}
+
+	identity := callerIdentity(r)
+	if identity.Role == RolePassenger {
+		var tripId int64
+		err = db.QueryRow("SELECT id FROM ongoing_trip WHERE driverId = ? AND passengerId = ?",
+			driverId, identity.UserId).Scan(&tripId)
+		if err == sql.ErrNoRows {
+			writeErrorStatus(w, r, "Only the passenger of the driver's trip may see their location", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			writeError(w, r, "DB err 1")
+			log.Println("getDriverLocation: Error in query" + err.Error())
+			return
+		}
+	} else if !ensureCallerPathOr(w, r, RoleDriver, reqId, PermReadTrips) {
+		return
+	}
+
+	location, ok := locations.Get(driverId)
+	if !ok {
+		writeErrorStatus(w, r, "Location of the driver is not known", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(location)
+}
@@ -13,6 +13,10 @@ import (
 const PORT = 21803
 
 func main() {
+	loadTokenSecret()
+	loadGeocoder()
+	loadNotifier()
+
 	db, err := sql.Open("mysql", "root@tcp(127.0.0.1:3306)/etia1tripmanagement")
 
 	// handle error
@@ -26,8 +30,13 @@ func main() {
 	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS"})
 	origins := handlers.AllowedOrigins([]string{"*"})
 
+	// Background work uses the db set by registerEndpoints
+	router := registerEndpoints(db)
+	startLocationExpiry()
+	startOfferExpiry()
+
 	log.Printf("Listening at http://localhost:%v", PORT)
-	err = http.ListenAndServe(fmt.Sprintf(":%v", PORT), handlers.CORS(header, methods, origins)(registerEndpoints(db)))
+	err = http.ListenAndServe(fmt.Sprintf(":%v", PORT), handlers.CORS(header, methods, origins)(router))
 
 	// Shouldn't get here
 	db.Close()
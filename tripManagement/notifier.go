@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Events users are notified of
+const (
+	EventTripOffered    = "trip_offered"
+	EventDriverAssigned = "driver_assigned"
+	EventNoDriverFound  = "no_driver_found"
+)
+
+// A notification about a trip for a passenger or driver
+type Notification struct {
+	UserId  int64  `json:"userId"`
+	Event   string `json:"event"`
+	TripId  int64  `json:"tripId"`
+	Message string `json:"message"`
+}
+
+// Delivers notifications to users, e.g. by push notification
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+var notifier Notifier
+
+// Sets up the notifier. Until a push notification provider is configured,
+// notifications are written to the file in SLEDAWAY_NOTIFICATIONS_FILE, or
+// to the log if not set.
+func loadNotifier() {
+	path := os.Getenv("SLEDAWAY_NOTIFICATIONS_FILE")
+	if path == "" {
+		log.Println("SLEDAWAY_NOTIFICATIONS_FILE is not set, notifications are logged")
+		notifier = logNotifier{}
+		return
+	}
+	notifier = &fileNotifier{path: path}
+}
+
+// Sends a notification, only logging failures as trips carry on regardless
+func notify(userId int64, event string, tripId int64, message string) {
+	err := notifier.Notify(Notification{
+		UserId:  userId,
+		Event:   event,
+		TripId:  tripId,
+		Message: message,
+	})
+	if err != nil {
+		log.Println("notify: Error in notify" + err.Error())
+	}
+}
+
+// Writes notifications to the log
+type logNotifier struct{}
+
+func (logNotifier) Notify(notification Notification) error {
+	log.Printf("Notification to user %v: %v (trip %v) %v",
+		notification.UserId, notification.Event, notification.TripId, notification.Message)
+	return nil
+}
+
+// A notification written by fileNotifier
+type OutboxNotification struct {
+	Time int64 `json:"time"`
+	Notification
+}
+
+// Appends notifications to a file, one JSON object per line, so they can be
+// read back in tests
+type fileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (n *fileNotifier) Notify(notification Notification) error {
+	data, err := json.Marshal(OutboxNotification{
+		Time:         time.Now().Unix(),
+		Notification: notification,
+	})
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+// States of an offer of a trip to a driver
+const (
+	OfferPending   = "pending"
+	OfferAccepted  = "accepted"
+	OfferDeclined  = "declined"
+	OfferExpired   = "expired"
+	OfferWithdrawn = "withdrawn"
+)
+
+// How long a driver has to accept an offer
+const offerLifetime = 30 * time.Second
+
+// How often expired offers are passed on to the next driver
+const offerExpiryInterval = 5 * time.Second
+
+// Number of drivers a trip is offered to before giving up
+const maxOfferAttempts = 3
+
+// Returned when the driver has no pending offer for the trip
+var errNoPendingOffer = errors.New("no pending offer")
+
+// Returned when the driver accepts an offer too late
+var errOfferExpired = errors.New("offer expired")
+
+// Returned when the driver picked for a trip is no longer available, has
+// been offered another trip or is on one
+var errDriverTaken = errors.New("driver taken")
+
+// Returned when the driver accepts an offer while on another trip
+var errDriverBusy = errors.New("driver already on a trip")
+
+// Checks if the error is from inserting a duplicate of a unique key
+func isDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// Offers the trip to the driver. Drivers are picked before the offer is made,
+// so the available driver is locked and checked again, making concurrent
+// offers to the same driver wait for each other. Returns errDriverTaken if the
+// driver can no longer be offered the trip.
+func offerTrip(tx *sql.Tx, tripId int64, driverId int64) error {
+	var available int64
+	err := tx.QueryRow("SELECT driverId FROM available_driver WHERE driverId = ? FOR UPDATE",
+		driverId).Scan(&available)
+	if err == sql.ErrNoRows {
+		return errDriverTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	var offers, trips int
+	err = tx.QueryRow("SELECT COUNT(*) FROM trip_offer WHERE driverId = ? AND status = ? FOR UPDATE",
+		driverId, OfferPending).Scan(&offers)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow("SELECT COUNT(*) FROM ongoing_trip WHERE driverId = ? FOR UPDATE",
+		driverId).Scan(&trips)
+	if err != nil {
+		return err
+	}
+	if offers > 0 || trips > 0 {
+		return errDriverTaken
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`INSERT INTO trip_offer
+		(tripId, driverId, status, createdAt, expiresAt)
+		VALUES (?, ?, ?, ?, ?)`,
+		tripId, driverId, OfferPending, now.Unix(), now.Add(offerLifetime).Unix())
+	return err
+}
+
+// The outcome of dispatching a trip, for notifying users once it is saved
+type dispatch struct {
+	tripId      int64
+	passengerId int64
+	// The driver the trip was offered to, or 0 if no driver was found
+	driverId int64
+}
+
+func (d dispatch) notify() {
+	if d.driverId == 0 {
+		notify(d.passengerId, EventNoDriverFound, d.tripId, "No driver could take your trip. Please try again later.")
+		return
+	}
+	notify(d.driverId, EventTripOffered, d.tripId,
+		"You have a new trip offer. Accept it within "+strconv.Itoa(int(offerLifetime/time.Second))+" seconds.")
+}
+
+// Picks the next driver to offer a trip to, i.e. the nearest driver it has
+// not been offered to yet, other than the taken drivers. Returns 0 once it
+// has been offered to too many drivers, or if no other driver is nearby.
+// Calls other services, so it must not be called with the trip locked.
+func nextDriver(tripId int64, postalCode string, taken map[int64]bool) (int64, error) {
+	rows, err := db.Query("SELECT driverId FROM trip_offer WHERE tripId = ?", tripId)
+	if err != nil {
+		return 0, err
+	}
+	offered := map[int64]bool{}
+	for rows.Next() {
+		var driverId int64
+		if err = rows.Scan(&driverId); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		offered[driverId] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(offered) >= maxOfferAttempts {
+		return 0, nil
+	}
+
+	pickup, err := geocoder.Locate(postalCode)
+	if err != nil {
+		return 0, err
+	}
+
+	for driverId := range taken {
+		offered[driverId] = true
+	}
+	driverId, err := findNearestDriver(pickup, offered)
+	if err == errNoDriverNearby {
+		return 0, nil
+	}
+	return driverId, err
+}
+
+// Ends the pending offer of the trip to the driver as declined or expired,
+// and offers the trip to the next driver. The trip is cancelled if there is
+// no next driver. Returns sql.ErrNoRows if there is no such trip, or
+// errNoPendingOffer if the offer has already ended.
+func redispatchTrip(tripId string, driverId int64, outcome string) error {
+	// The next driver is picked before locking the trip, so the offer is
+	// checked again once it is locked
+	var id int64
+	var postalCode string
+	var pending bool
+	err := db.QueryRow(`SELECT t.id, t.postalCode, o.id IS NOT NULL
+		FROM ongoing_trip t
+		LEFT JOIN trip_offer o ON t.id = o.tripId AND o.driverId = ? AND o.status = ?
+		WHERE t.id = ?`,
+		driverId, OfferPending, tripId,
+	).Scan(&id, &postalCode, &pending)
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return errNoPendingOffer
+	}
+
+	// Drivers taken by another trip in the meantime are passed over
+	taken := map[int64]bool{}
+	for {
+		nextDriverId, err := nextDriver(id, postalCode, taken)
+		if err != nil {
+			return err
+		}
+
+		err = passOnOffer(tripId, driverId, outcome, nextDriverId)
+		if err != errDriverTaken {
+			return err
+		}
+		taken[nextDriverId] = true
+	}
+}
+
+// Ends the pending offer of the trip to the driver with the outcome, and
+// offers the trip to the next driver, or cancels it if there is none
+func passOnOffer(tripId string, driverId int64, outcome string, nextDriverId int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	trip, err := lockTrip(tx, tripId)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`UPDATE trip_offer
+		SET status = ?, respondedAt = ?
+		WHERE tripId = ? AND driverId = ? AND status = ?`,
+		outcome, time.Now().Unix(), trip.Id, driverId, OfferPending)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errNoPendingOffer
+	}
+
+	if nextDriverId == 0 {
+		_, err = transitionTripTx(tx, tripId, TripCancelled, nil)
+	} else {
+		err = offerTrip(tx, trip.Id, nextDriverId)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	dispatch{tripId: trip.Id, passengerId: trip.PassengerId, driverId: nextDriverId}.notify()
+	return nil
+}
+
+// Assigns the driver to the trip if they have a pending offer for it
+func acceptTripOffer(tripId string, driverId int64) (OngoingTrip, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return OngoingTrip{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	trip, err := transitionTripTx(tx, tripId, TripDriverAssigned, func(trip OngoingTrip) error {
+		var expiresAt int64
+		err := tx.QueryRow(`SELECT expiresAt FROM trip_offer
+			WHERE tripId = ? AND driverId = ? AND status = ?
+			FOR UPDATE`,
+			trip.Id, driverId, OfferPending,
+		).Scan(&expiresAt)
+		if err == sql.ErrNoRows {
+			return errNoPendingOffer
+		}
+		if err != nil {
+			return err
+		}
+
+		// Expired offers are left for the dispatcher to pass on
+		if expiresAt <= now {
+			return errOfferExpired
+		}
+		return nil
+	})
+	if err != nil {
+		return trip, err
+	}
+
+	_, err = tx.Exec(`UPDATE trip_offer
+		SET status = ?, respondedAt = ?
+		WHERE tripId = ? AND driverId = ? AND status = ?`,
+		OfferAccepted, now, trip.Id, driverId, OfferPending)
+	if err != nil {
+		return trip, err
+	}
+
+	// Each driver may only be on one trip at a time
+	_, err = tx.Exec("UPDATE ongoing_trip SET driverId = ? WHERE id = ?", driverId, trip.Id)
+	if isDuplicate(err) {
+		return trip, errDriverBusy
+	}
+	if err != nil {
+		return trip, err
+	}
+
+	trip.DriverId = driverId
+	return trip, tx.Commit()
+}
+
+// Passes expired offers on to the next driver
+func expireOffers() {
+	rows, err := db.Query("SELECT tripId, driverId FROM trip_offer WHERE status = ? AND expiresAt <= ?",
+		OfferPending, time.Now().Unix())
+	if err != nil {
+		log.Println("expireOffers: Error in query" + err.Error())
+		return
+	}
+
+	type expiredOffer struct {
+		tripId   int64
+		driverId int64
+	}
+	var offers []expiredOffer
+	for rows.Next() {
+		var offer expiredOffer
+		if err = rows.Scan(&offer.tripId, &offer.driverId); err != nil {
+			log.Println("expireOffers: Error in scan" + err.Error())
+			break
+		}
+		offers = append(offers, offer)
+	}
+	rows.Close()
+
+	// Each trip is passed on at the same time, so a slow one does not hold
+	// up the others
+	var wg sync.WaitGroup
+	for _, offer := range offers {
+		wg.Add(1)
+		go func(offer expiredOffer) {
+			defer wg.Done()
+			err := redispatchTrip(strconv.FormatInt(offer.tripId, 10), offer.driverId, OfferExpired)
+			if err != nil && err != errNoPendingOffer && err != sql.ErrNoRows {
+				log.Println("expireOffers: Error in redispatch" + err.Error())
+			}
+		}(offer)
+	}
+	wg.Wait()
+}
+
+// Passes expired offers on in the background
+func startOfferExpiry() {
+	go func() {
+		for range time.Tick(offerExpiryInterval) {
+			expireOffers()
+		}
+	}()
+}
+
+// --------------
+
+// Writes the errors of offers, returning whether it was written
+func writeOfferError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch err {
+	case errNoPendingOffer:
+		writeErrorStatus(w, r, "You have no pending offer for this trip", http.StatusNotFound)
+	case errOfferExpired:
+		writeErrorCode(w, r, "The offer has expired", http.StatusConflict, CodeOfferExpired)
+	case errDriverBusy:
+		writeErrorStatus(w, r, "You are already on another trip", http.StatusConflict)
+	default:
+		return false
+	}
+	return true
+}
+
+type AcceptOfferResponse struct {
+	Status      string `json:"status"`
+	PassengerId int64  `json:"passengerId"`
+	PostalCode  string `json:"postalCode"`
+}
+
+// Accepts the offer of a trip, assigning the driver to it
+func acceptOffer(w http.ResponseWriter, r *http.Request) {
+	tripReqId := mux.Vars(r)["id"]
+
+	var driverId int64
+	if !ensureCaller(w, r, RoleDriver, &driverId) {
+		return
+	}
+
+	trip, err := acceptTripOffer(tripReqId, driverId)
+	if writeOfferError(w, r, err) || writeTransitionError(w, r, tripReqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("acceptOffer: Error in accept" + err.Error())
+		return
+	}
+
+	notify(trip.PassengerId, EventDriverAssigned, trip.Id, "A driver has accepted your trip and is on the way.")
+
+	json.NewEncoder(w).Encode(AcceptOfferResponse{
+		Status:      trip.Status,
+		PassengerId: trip.PassengerId,
+		PostalCode:  trip.PostalCode,
+	})
+}
+
+// Declines the offer of a trip, passing it on to the next driver
+func declineOffer(w http.ResponseWriter, r *http.Request) {
+	tripReqId := mux.Vars(r)["id"]
+
+	var driverId int64
+	if !ensureCaller(w, r, RoleDriver, &driverId) {
+		return
+	}
+
+	err := redispatchTrip(tripReqId, driverId, OfferDeclined)
+	if writeOfferError(w, r, err) || writeTransitionError(w, r, tripReqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("declineOffer: Error in redispatch" + err.Error())
+		return
+	}
+}
+
+type GetDriverOfferResponse struct {
+	TripId     int64  `json:"tripId"`
+	PostalCode string `json:"postalCode"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+// Gets the pending offer of the driver
+func getDriverOffer(w http.ResponseWriter, r *http.Request) {
+	var resp GetDriverOfferResponse
+	reqId := mux.Vars(r)["id"]
+
+	if !ensureCallerPathOr(w, r, RoleDriver, reqId, PermReadTrips) {
+		return
+	}
+
+	err := db.QueryRow(`SELECT o.tripId, t.postalCode, o.expiresAt
+		FROM trip_offer o INNER JOIN ongoing_trip t ON o.tripId = t.id
+		WHERE o.driverId = ? AND o.status = ? AND o.expiresAt > ?`,
+		reqId, OfferPending, time.Now().Unix(),
+	).Scan(&resp.TripId, &resp.PostalCode, &resp.ExpiresAt)
+	if err == sql.ErrNoRows {
+		writeErrorStatus(w, r, "Driver has no pending offer: "+reqId, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("getDriverOffer: Error in query" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Role of tokens this service issues to call other services on its own
+// behalf
+const RoleService = "service"
+
+// Lifetime of a service token, only long enough for a single call
+const serviceTokenLifetime = time.Minute
+
+// Permissions to act on resources other than the caller's own
+const (
+	PermReadAccounts = "accounts:read"
+	PermReadTrips    = "trips:read"
+	PermManageTrips  = "trips:manage"
+	PermReadHistory  = "history:read"
+	PermWriteHistory = "history:write"
+)
+
+// Permissions of each role used by this service. Must match accountManagement.
+var rolePermissions = map[string][]string{
+	"support": {
+		PermReadAccounts, PermReadTrips, PermReadHistory,
+	},
+	"admin": {
+		PermReadAccounts, PermReadTrips, PermManageTrips, PermReadHistory,
+	},
+	RoleService: {
+		PermReadAccounts, PermWriteHistory,
+	},
+}
+
+// Checks whether the caller's role grants the permission
+func hasPermission(identity Identity, permission string) bool {
+	for _, p := range rolePermissions[identity.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware for a single route that only allows callers with the
+// permission. Must run after authenticate.
+func requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(callerIdentity(r), permission) {
+			writeErrorStatus(w, r, "You do not have permission to do this", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Same as ensureCallerPath, but callers with the permission may act on any
+// user
+func ensureCallerPathOr(w http.ResponseWriter, r *http.Request, role string, reqId string, permission string) bool {
+	if hasPermission(callerIdentity(r), permission) {
+		return true
+	}
+	return ensureCallerPath(w, r, role, reqId)
+}
+
+// Same as ensureSelfPath, but callers with the permission may act on any user
+func ensureSelfPathOr(w http.ResponseWriter, r *http.Request, reqId string, permission string) bool {
+	if hasPermission(callerIdentity(r), permission) {
+		return true
+	}
+	return ensureSelfPath(w, r, reqId)
+}
+
+// Signs a short lived token for calling other services as this service
+func issueServiceToken() (string, error) {
+	now := time.Now()
+	claims := AccessClaims{
+		Role: RoleService,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "0",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(serviceTokenLifetime)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tokenSecret)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long a session found active is trusted before it is checked again
+const sessionCheckInterval = 10 * time.Second
+
+// Returned when the session of an access token has been signed out
+var errSessionRevoked = errors.New("session revoked")
+
+// Sessions recently found active by accountManagement, by id
+type sessionCache struct {
+	mu        sync.Mutex
+	checkedAt map[int64]time.Time
+}
+
+var sessions = &sessionCache{checkedAt: map[int64]time.Time{}}
+
+// Checks with accountManagement that the session of an access token, given
+// as its Authorization header, has not been signed out, e.g. by logging out
+// or resetting the password. Returns errSessionRevoked if it has.
+func (c *sessionCache) Check(sessionId int64, authorization string) error {
+	c.mu.Lock()
+	checkedAt, ok := c.checkedAt[sessionId]
+	c.mu.Unlock()
+	if ok && time.Since(checkedAt) < sessionCheckInterval {
+		return nil
+	}
+
+	request, err := http.NewRequest(http.MethodGet, accountManagementApiUrl+"/api/v1/auth/session", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", authorization)
+
+	resp, err := serviceClient.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return errSessionRevoked
+	default:
+		return errors.New("unexpected status from accountManagement: " + resp.Status)
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, checkedAt := range c.checkedAt {
+		if now.Sub(checkedAt) >= sessionCheckInterval {
+			delete(c.checkedAt, id)
+		}
+	}
+	c.checkedAt[sessionId] = now
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// States of a trip. Completed and cancelled trips are no longer ongoing, so
+// they are removed instead of being stored.
+const (
+	TripRequested      = "requested"
+	TripDriverAssigned = "driver_assigned"
+	TripDriverArrived  = "driver_arrived"
+	TripInProgress     = "in_progress"
+	TripCompleted      = "completed"
+	TripCancelled      = "cancelled"
+)
+
+// The states each state may move to. A driver may start a trip without
+// saying they have arrived first.
+var tripTransitions = map[string][]string{
+	TripRequested:      {TripDriverAssigned, TripCancelled},
+	TripDriverAssigned: {TripDriverArrived, TripInProgress, TripCancelled},
+	TripDriverArrived:  {TripInProgress, TripCancelled},
+	TripInProgress:     {TripCompleted},
+}
+
+func canTransition(from string, to string) bool {
+	for _, next := range tripTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Returned when a trip cannot move from its current state to the next
+type TransitionError struct {
+	Current string
+	Next    string
+}
+
+func (e *TransitionError) Error() string {
+	return "trip cannot move from " + e.Current + " to " + e.Next
+}
+
+// Returned by a guard when the caller is not the driver of the trip
+var errNotAssignedDriver = errors.New("not the assigned driver of the trip")
+
+// Returned by a guard when the caller is not part of the trip
+var errNotTripParticipant = errors.New("not a participant of the trip")
+
+// An ongoing trip as stored
+type OngoingTrip struct {
+	Id          int64
+	PostalCode  string
+	PassengerId int64
+	DriverId    int64
+	StartTime   *int64
+	Status      string
+}
+
+// Checks a trip before it is moved to the next state, returning an error to
+// leave it as is. Every endpoint that changes a trip on behalf of its
+// passenger or driver must check them with a guard.
+type tripGuard func(trip OngoingTrip) error
+
+// Moves an ongoing trip to the next state. The trip is locked while it is
+// checked, so only one of concurrent transitions succeeds. Starting a trip
+// sets its start time, and completing or cancelling it removes it along with
+// its pending offer. Returns sql.ErrNoRows if there is no such trip, a
+// *TransitionError if the move is not allowed, or the error of the guard.
+// The guard is only nil for staff and the dispatcher.
+func transitionTrip(tripId string, next string, guard tripGuard) (OngoingTrip, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return OngoingTrip{}, err
+	}
+	defer tx.Rollback()
+
+	trip, err := transitionTripTx(tx, tripId, next, guard)
+	if err != nil {
+		return trip, err
+	}
+	return trip, tx.Commit()
+}
+
+// Gets an ongoing trip and locks it until the transaction ends. Returns
+// sql.ErrNoRows if there is no such trip.
+func lockTrip(tx *sql.Tx, tripId string) (OngoingTrip, error) {
+	var trip OngoingTrip
+
+	// The driver is not known until they accept an offer
+	err := tx.QueryRow(`SELECT
+		id, postalCode, passengerId, COALESCE(driverId, 0), startTime, status
+		FROM ongoing_trip
+		WHERE id = ?
+		FOR UPDATE`, tripId).Scan(
+		&trip.Id, &trip.PostalCode, &trip.PassengerId,
+		&trip.DriverId, &trip.StartTime, &trip.Status,
+	)
+	return trip, err
+}
+
+// Checks that an ongoing trip may move to the next state, without moving it.
+// Returns the same errors as transitionTrip.
+func checkTransition(tripId string, next string, guard tripGuard) (OngoingTrip, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return OngoingTrip{}, err
+	}
+	defer tx.Rollback()
+
+	return checkTransitionTx(tx, tripId, next, guard)
+}
+
+// Same as checkTransition, leaving the trip locked in the transaction
+func checkTransitionTx(tx *sql.Tx, tripId string, next string, guard tripGuard) (OngoingTrip, error) {
+	trip, err := lockTrip(tx, tripId)
+	if err != nil {
+		return trip, err
+	}
+
+	if guard != nil {
+		if err = guard(trip); err != nil {
+			return trip, err
+		}
+	}
+	if !canTransition(trip.Status, next) {
+		return trip, &TransitionError{Current: trip.Status, Next: next}
+	}
+	return trip, nil
+}
+
+// Same as transitionTrip, in a transaction that is left to the caller
+func transitionTripTx(tx *sql.Tx, tripId string, next string, guard tripGuard) (OngoingTrip, error) {
+	trip, err := checkTransitionTx(tx, tripId, next, guard)
+	if err != nil {
+		return trip, err
+	}
+
+	switch next {
+	case TripCompleted, TripCancelled:
+		_, err = tx.Exec("DELETE FROM ongoing_trip WHERE id = ?", trip.Id)
+		if err == nil {
+			_, err = tx.Exec("UPDATE trip_offer SET status = ? WHERE tripId = ? AND status = ?",
+				OfferWithdrawn, trip.Id, OfferPending)
+		}
+	case TripInProgress:
+		startTime := time.Now().Unix()
+		trip.StartTime = &startTime
+		_, err = tx.Exec("UPDATE ongoing_trip SET status = ?, startTime = ? WHERE id = ?", next, startTime, trip.Id)
+	default:
+		_, err = tx.Exec("UPDATE ongoing_trip SET status = ? WHERE id = ?", next, trip.Id)
+	}
+	if err != nil {
+		return trip, err
+	}
+
+	trip.Status = next
+	return trip, nil
+}
+
+// Only lets the driver assigned to the trip move it
+func assignedDriverGuard(driverId int64) tripGuard {
+	return func(trip OngoingTrip) error {
+		if trip.DriverId != driverId {
+			return errNotAssignedDriver
+		}
+		return nil
+	}
+}
+
+// Only lets the passenger or driver of the trip move it, signed in as such.
+// Trips without a driver have a DriverId of 0, the same as the UserId of
+// service tokens, so callers without a user never pass.
+func participantGuard(identity Identity) tripGuard {
+	return func(trip OngoingTrip) error {
+		if identity.UserId == 0 {
+			return errNotTripParticipant
+		}
+		switch {
+		case identity.Role == RolePassenger && trip.PassengerId == identity.UserId:
+		case identity.Role == RoleDriver && trip.DriverId == identity.UserId:
+		default:
+			return errNotTripParticipant
+		}
+		return nil
+	}
+}
+
+// Given when a trip cannot move to the next state
+type TripConflictResponse struct {
+	RegularResponse
+	// The current state of the trip
+	TripStatus string `json:"tripStatus"`
+}
+
+// Writes the error of transitionTrip if it is not found, not allowed or
+// refused by the guard, returning whether it was written. Other errors are
+// left to the caller.
+func writeTransitionError(w http.ResponseWriter, r *http.Request, tripId string, err error) bool {
+	var transitionErr *TransitionError
+	switch {
+	case err == sql.ErrNoRows:
+		writeErrorStatus(w, r, "Trip not found: "+tripId, http.StatusNotFound)
+	case err == errNotAssignedDriver:
+		writeErrorStatus(w, r, "Only the driver assigned to this trip may do this", http.StatusForbidden)
+	case err == errNotTripParticipant:
+		writeErrorStatus(w, r, "You are not part of this trip", http.StatusForbidden)
+	case errors.As(err, &transitionErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(TripConflictResponse{
+			RegularResponse: RegularResponse{
+				Status:      false,
+				Description: "Trip is " + transitionErr.Current + " and cannot be " + transitionErr.Next,
+				Code:        CodeInvalidTransition,
+			},
+			TripStatus: transitionErr.Current,
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// --------------
+
+type TripStatusResponse struct {
+	Status string `json:"status"`
+}
+
+// Marks that the driver has arrived at the pickup
+func arriveAtTrip(w http.ResponseWriter, r *http.Request) {
+	tripReqId := mux.Vars(r)["id"]
+
+	var driverId int64
+	if !ensureCaller(w, r, RoleDriver, &driverId) {
+		return
+	}
+
+	trip, err := transitionTrip(tripReqId, TripDriverArrived, assignedDriverGuard(driverId))
+	if writeTransitionError(w, r, tripReqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("arriveAtTrip: Error in transition" + err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(TripStatusResponse{Status: trip.Status})
+}
+
+// Cancels a trip that has not started yet, for either its passenger or its
+// driver, or for staff who manage trips. Cancelled trips are not archived, as
+// they never took place.
+func cancelTrip(w http.ResponseWriter, r *http.Request) {
+	tripReqId := mux.Vars(r)["id"]
+
+	identity := callerIdentity(r)
+	guard := participantGuard(identity)
+	if hasPermission(identity, PermManageTrips) {
+		guard = nil
+	}
+
+	trip, err := transitionTrip(tripReqId, TripCancelled, guard)
+	if writeTransitionError(w, r, tripReqId, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, "DB err 1")
+		log.Println("cancelTrip: Error in transition" + err.Error())
+		return
+	}
+
+	log.Println("cancelTrip: Trip", trip.Id, "cancelled by user", identity.UserId)
+	json.NewEncoder(w).Encode(TripStatusResponse{Status: trip.Status})
+}